@@ -0,0 +1,196 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+)
+
+//Date buckets of ?group=column:bucket, the buckets are strings : 2021-01-31 for a day, the monday for a week and 2021-01 for a month
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+//MaxAggregateRows maximum number of groups returned by an aggregate
+var MaxAggregateRows = 1000
+
+//aggregate functions of ?metrics=
+var aggregateFuncs = map[string]string{"count": "COUNT", "sum": "SUM", "avg": "AVG", "min": "MIN", "max": "MAX"}
+
+//AggregateGroup a column to group on, with an optional date bucket
+type AggregateGroup struct {
+	Column string
+	Bucket string
+}
+
+//Alias return the key of the group in the results : column or column_bucket
+func (g AggregateGroup) Alias() string {
+	if g.Bucket != "" {
+		return g.Column + "_" + g.Bucket
+	}
+	return g.Column
+}
+
+//Expression return the sql of the group, the date bucket as a string of the dialect
+func (g AggregateGroup) Expression(db *gorm.DB) (string, error) {
+	if g.Bucket == "" {
+		return g.Column, nil
+	}
+	c := g.Column
+	formats := map[string]map[string]string{
+		"sqlite": {
+			BucketDay:   "strftime('%Y-%m-%d', " + c + ")",
+			BucketWeek:  "date(" + c + ", 'weekday 0', '-6 days')",
+			BucketMonth: "strftime('%Y-%m', " + c + ")",
+		},
+		"postgres": {
+			BucketDay:   "to_char(" + c + ", 'YYYY-MM-DD')",
+			BucketWeek:  "to_char(date_trunc('week', " + c + "), 'YYYY-MM-DD')",
+			BucketMonth: "to_char(" + c + ", 'YYYY-MM')",
+		},
+		"mysql": {
+			BucketDay:   "DATE_FORMAT(" + c + ", '%Y-%m-%d')",
+			BucketWeek:  "DATE_FORMAT(DATE_SUB(" + c + ", INTERVAL WEEKDAY(" + c + ") DAY), '%Y-%m-%d')",
+			BucketMonth: "DATE_FORMAT(" + c + ", '%Y-%m')",
+		},
+		"sqlserver": {
+			BucketDay:   "FORMAT(" + c + ", 'yyyy-MM-dd')",
+			BucketWeek:  "FORMAT(DATEADD(day, -((DATEPART(weekday, " + c + ") + @@DATEFIRST + 5) % 7), " + c + "), 'yyyy-MM-dd')",
+			BucketMonth: "FORMAT(" + c + ", 'yyyy-MM')",
+		},
+	}[db.Dialector.Name()]
+	if formats == nil {
+		return "", fmt.Errorf("date buckets not available on %s", db.Dialector.Name())
+	}
+	return formats[g.Bucket], nil
+}
+
+//AggregateMetric count or an aggregate function of a column
+type AggregateMetric struct {
+	Func   string
+	Column string //empty to count the rows
+}
+
+//Alias return the key of the metric in the results : count or sum_amount
+func (m AggregateMetric) Alias() string {
+	if m.Column == "" {
+		return m.Func
+	}
+	return m.Func + "_" + m.Column
+}
+
+//Expression return the sql of the metric
+func (m AggregateMetric) Expression() string {
+	if m.Column == "" {
+		return aggregateFuncs[m.Func] + "(*)"
+	}
+	return aggregateFuncs[m.Func] + "(" + m.Column + ")"
+}
+
+//GetAggregate return the groups and the metrics of ?group=status,created_at:month&metrics=count,sum:amount
+//columns must be exactly one of the Aggregatable columns, metrics default to count
+func GetAggregate(r *http.Request, data Validation) ([]AggregateGroup, []AggregateMetric, error) {
+	v, ok := data.(Aggregatable)
+	if !ok {
+		return nil, nil, fmt.Errorf("aggregate not available on %s", data.TableName())
+	}
+	groupable, aggregatable := map[string]bool{}, map[string]bool{}
+	for _, c := range v.GroupColumns() {
+		groupable[c] = true
+	}
+	for _, c := range v.AggregateColumns() {
+		aggregatable[c] = true
+	}
+	groups := []AggregateGroup{}
+	if group := r.FormValue("group"); group != "" {
+		for _, g := range strings.Split(group, ",") {
+			parts := strings.SplitN(strings.TrimSpace(g), ":", 2)
+			key := AggregateGroup{Column: parts[0]}
+			if !groupable[key.Column] { //avoid sql injection on groups
+				return nil, nil, fmt.Errorf("can't group on %q", key.Column)
+			}
+			if len(parts) == 2 {
+				key.Bucket = parts[1]
+				if key.Bucket != BucketDay && key.Bucket != BucketWeek && key.Bucket != BucketMonth {
+					return nil, nil, fmt.Errorf("unknown date bucket %q", key.Bucket)
+				}
+			}
+			groups = append(groups, key)
+		}
+	}
+	metrics := []AggregateMetric{}
+	for _, m := range strings.Split(r.FormValue("metrics"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(m), ":", 2)
+		if parts[0] == "" {
+			continue
+		}
+		metric := AggregateMetric{Func: parts[0]}
+		if _, ok := aggregateFuncs[metric.Func]; !ok {
+			return nil, nil, fmt.Errorf("unknown metric %q", metric.Func)
+		}
+		if len(parts) == 2 {
+			metric.Column = parts[1]
+		}
+		if (metric.Column != "" || metric.Func != "count") && !aggregatable[metric.Column] {
+			return nil, nil, fmt.Errorf("can't aggregate %q", metric.Column)
+		}
+		metrics = append(metrics, metric)
+	}
+	if len(metrics) == 0 {
+		metrics = append(metrics, AggregateMetric{Func: "count"})
+	}
+	return groups, metrics, nil
+}
+
+//GenericAggregate return the metrics of each group with the same filters as GenericGetQueryAll
+func GenericAggregate(w http.ResponseWriter, r *http.Request, data Validation, freq func(r *http.Request, req *gorm.DB) *gorm.DB) {
+	groups, metrics, err := GetAggregate(r, data)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, err.Error()))
+		return
+	}
+	filter, err := filterParam(r)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidFilter, err.Error()))
+		return
+	}
+	req, err := filteredList(r, data, data.QueryAllFromRequest(r, GetDB()).Model(data), freq, filter)
+	if err != nil {
+		respondListError(w, r, err)
+		return
+	}
+	selects, groupBy := []string{}, []string{}
+	for _, g := range groups {
+		expr, err := g.Expression(req)
+		if err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, err.Error()))
+			return
+		}
+		selects = append(selects, expr+" AS "+req.Statement.Quote(g.Alias()))
+		groupBy = append(groupBy, expr)
+	}
+	for _, m := range metrics {
+		selects = append(selects, m.Expression()+" AS "+req.Statement.Quote(m.Alias()))
+	}
+	req = req.Select(strings.Join(selects, ", "))
+	for _, g := range groupBy {
+		req = req.Group(g).Order(g)
+	}
+	rows := []map[string]interface{}{}
+	if err := req.Limit(MaxAggregateRows + 1).Scan(&rows).Error; err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+		return
+	}
+	if len(rows) > MaxAggregateRows {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, fmt.Sprintf("More than %d groups", MaxAggregateRows)))
+		return
+	}
+	resp := utils.Message(true, "data returned")
+	resp["data"] = rows
+	utils.Respond(w, resp)
+}
@@ -1,14 +1,28 @@
 package api
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/loupzeur/go-crud-api/middlewares"
 	"github.com/loupzeur/go-crud-api/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -97,3 +111,1707 @@ func (c *TestObject) FindFromRequest(r *http.Request) error {
 func (c *TestObject) QueryAllFromRequest(r *http.Request, q *gorm.DB) *gorm.DB {
 	return DefaultQueryAll(r, q)
 }
+
+//Test helpers
+
+//TestItem a richer object to test filters, orders, ...
+type TestItem struct {
+	ID        uint `gorm:"primarykey"`
+	Name      string
+	Price     float64
+	Status    string
+	Note      *string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt
+	OwnerID   *uint
+	Owner     *TestOwner
+	Version   uint
+	History   []map[string]interface{} `gorm:"-" json:",omitempty"`
+}
+
+//TestOwner an association of TestItem
+type TestOwner struct {
+	ID    uint `gorm:"primarykey"`
+	Name  string
+	Email string
+}
+
+func (c *TestItem) TableName() string {
+	return "test_item"
+}
+
+func (c *TestItem) Validate() (map[string]interface{}, bool) {
+	if c.Name == "" {
+		return utils.Message(false, "Name is empty"), false
+	}
+	return nil, true
+}
+
+func (c *TestItem) OrderColumns() []string {
+	return []string{"name", "price", "status", "created_at"}
+}
+
+func (c *TestItem) SortTypes() map[string]string {
+	return map[string]string{"name": SortNoCase, "price": SortNumeric}
+}
+
+func (c *TestItem) FilterColumns() map[string]string {
+	return map[string]string{
+		"name":   "stringlike,ilike,eq",
+		"price":  "eq,gt,gte,lt,lte",
+		"status": "in,ne",
+		"note":   "null",
+	}
+}
+
+func (c *TestItem) GroupColumns() []string {
+	return []string{"status", "created_at"}
+}
+
+func (c *TestItem) AggregateColumns() []string {
+	return []string{"price"}
+}
+
+func (c *TestItem) ExportColumns() []ExportColumn {
+	return []ExportColumn{
+		{Header: "Name", Field: "Name"},
+		{Header: "Price", Field: "price"},
+		{Header: "Status", Field: "Status", Format: func(v interface{}) string { return strings.ToUpper(v.(string)) }},
+		{Header: "Created", Field: "CreatedAt"},
+		{Header: "Note", Field: "Note", Raw: true},
+	}
+}
+
+func (c *TestItem) SelectableFields() []string {
+	return []string{"id", "name", "price", "owner.name"}
+}
+
+func (c *TestItem) Includes() map[string]utils.RightBits {
+	return map[string]utils.RightBits{"owner": 4}
+}
+
+func (c *TestItem) GetHistoryFields() map[string]string {
+	return map[string]string{"Name": "name", "Price": "price", "Note": "note", "CreatedAt": "created_at"}
+}
+
+func (c *TestItem) SetHistory(history []map[string]interface{}) {
+	c.History = history
+}
+
+func (c *TestItem) VersionColumn() string {
+	return "version"
+}
+
+func (c *TestItem) SoftDeleteRights() SoftDeleteRights {
+	return SoftDeleteRights{Trash: 2, Restore: 2, Hard: 8}
+}
+
+func (c *TestItem) FindFromRequest(r *http.Request) error {
+	return utils.DefaultFindFromRequest(r, GetDB(), c)
+}
+
+func (c *TestItem) QueryAllFromRequest(r *http.Request, q *gorm.DB) *gorm.DB {
+	return DefaultQueryAll(r, q)
+}
+
+//setupTestItems open a fresh memory db with some items and return a router serving routes
+func setupTestItems(t *testing.T, routes utils.Routes) *mux.Router {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDB(db)
+	db.AutoMigrate(&TestOwner{}, &TestItem{})
+	note := "note"
+	owner := TestOwner{Name: "bob", Email: "bob@example.com"}
+	db.Create(&owner)
+	for i, v := range []TestItem{
+		{Name: "apple", Price: 5, Status: "open", Note: &note, OwnerID: &owner.ID},
+		{Name: "Banana", Price: 12, Status: "open"},
+		{Name: "cherry", Price: 30, Status: "closed"},
+		{Name: "date", Price: 50, Status: "closed"},
+	} {
+		v.CreatedAt = time.Date(2021, 1, i+1, 0, 0, 0, 0, time.UTC)
+		db.Create(&v)
+	}
+	router := mux.NewRouter().StrictSlash(true)
+	router.Use(middlewares.JwtAuthentication)
+	middlewares.Routes = routes
+	for _, route := range routes {
+		router.Methods(route.Method).Path(route.Pattern).Handler(route.HandlerFunc).Name(route.Name)
+	}
+	return router
+}
+
+func testItemRoutes() utils.Routes {
+	return openResource(&TestItem{}).Routes()
+}
+
+//extraVerbs the verbs on top of List, Get, Create, Update and Delete
+var extraVerbs = []Verb{Search, Patch, Bulk, Trash, Restore, History, Revert, Associations, Aggregate, Export, Import}
+
+//openResource return the builder of model with all verbs set and enabled, without rights
+func openResource(model Validation) *ResourceBuilder {
+	return Resource(model).
+		List(DefaultQueryAll, utils.NoRight).
+		Get(DefaultRightAccess, utils.NoRight).
+		Create(DefaultRightAccess, utils.NoRight).
+		Update(DefaultRightEdit, utils.NoRight).
+		Delete(DefaultRightAccess, utils.NoRight).
+		Enable(extraVerbs...)
+}
+
+//authHeader return the Authorization header of a user with rights
+func authHeader(userID uint, rights utils.RightBits) map[string]string {
+	tk := &utils.Token{
+		UserId:     userID,
+		UserRights: rights,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}
+	token, _ := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), tk).SignedString([]byte(os.Getenv("token_password")))
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+//names return the names of the items returned
+func names(resp map[string]interface{}) string {
+	ret := []string{}
+	data, _ := resp["data"].([]interface{})
+	for _, v := range data {
+		ret = append(ret, v.(map[string]interface{})["Name"].(string))
+	}
+	return strings.Join(ret, ",")
+}
+
+//doRequest execute the request on router and decode the json response
+func doRequest(t *testing.T, router http.Handler, method string, url string, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return doRequestHeaders(t, router, method, url, body, nil)
+}
+
+//doRequestHeaders execute the request with headers on router and decode the json response
+func doRequestHeaders(t *testing.T, router http.Handler, method string, url string, body string, headers map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	resp := map[string]interface{}{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr, resp
+}
+
+func TestFilterOperators(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	for url, expected := range map[string]int{
+		"/api/test_item?price[gte]=10&price[lt]=50": 2,
+		"/api/test_item?name[ilike]=BAN":            1,
+		"/api/test_item?note[null]=true":            3,
+		"/api/test_item?status=open":                2,
+		"/api/test_item?status[ne]=open":            2,
+		"/api/test_item?name=an":                    1,
+	} {
+		rr, resp := doRequest(t, router, "GET", url, "")
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s : return code %d", url, rr.Code)
+			continue
+		}
+		if nb := len(resp["data"].([]interface{})); nb != expected {
+			t.Errorf("%s : expected %d values got %d", url, expected, nb)
+		}
+	}
+	for _, url := range []string{
+		"/api/test_item?price[foo]=1",
+		"/api/test_item?price[like]=1",
+		"/api/test_item?note[null]=maybe",
+		"/api/test_item?secret[eq]=1",
+		"/api/test_item?created_at[gte]=2021-01-02",
+	} {
+		if rr, _ := doRequest(t, router, "GET", url, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s : expected 400 got %d", url, rr.Code)
+		}
+	}
+}
+
+func TestSearchFilter(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	for body, expected := range map[string]int{
+		`{"or": [{"status": {"in": ["closed"]}}, {"name": "apple"}]}`:                           3,
+		`{"and": [{"price": {"gte": 10}}, {"not": {"or": [{"name": "date"}, {"price": 12}]}}]}`: 1,
+		`{"price": {"gt": 5, "lt": 50}, "status": {"ne": "open"}}`:                              1,
+		`{"status": "open"}`:             2, //first declared mode in
+		`{"status": ["open", "closed"]}`: 4,
+		`{"name": "an"}`:                 1, //first declared mode stringlike
+		``:                               4,
+	} {
+		rr, resp := doRequest(t, router, "POST", "/api/test_item/search", body)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s : return code %d", body, rr.Code)
+			continue
+		}
+		if nb := len(resp["data"].([]interface{})); nb != expected {
+			t.Errorf("%s : expected %d values got %d", body, expected, nb)
+		}
+	}
+	deep := `{"price": 1}`
+	for i := 0; i < MaxFilterDepth; i++ {
+		deep = `{"not": ` + deep + `}`
+	}
+	for _, body := range []string{
+		`{"secret": 1}`,
+		`{"price": {"like": 1}}`,
+		`{"or": []}`,
+		`{"status": null}`,
+		deep,
+	} {
+		if rr, _ := doRequest(t, router, "POST", "/api/test_item/search", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s : expected 400 got %d", body, rr.Code)
+		}
+	}
+	if rr, resp := doRequest(t, router, "GET", `/api/test_item?filter={"name":{"ilike":"A"}}`, ""); rr.Code != http.StatusOK || len(resp["data"].([]interface{})) != 3 {
+		t.Errorf("filter param : return code %d", rr.Code)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	rr, resp := doRequest(t, router, "GET", "/api/test_item?order=price_desc&cursor=&limit=3", "")
+	if rr.Code != http.StatusOK || names(resp) != "date,cherry,Banana" || resp["prev_cursor"] != nil {
+		t.Fatalf("first page : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := resp["total_nb_values"]; ok {
+		t.Errorf("count should be skipped")
+	}
+	rr, resp = doRequest(t, router, "GET", "/api/test_item?order=price_desc&limit=3&cursor="+resp["next_cursor"].(string), "")
+	if rr.Code != http.StatusOK || names(resp) != "apple" || resp["next_cursor"] != nil {
+		t.Fatalf("second page : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "GET", "/api/test_item?order=price_desc&limit=3&cursor="+resp["prev_cursor"].(string), "")
+	if rr.Code != http.StatusOK || names(resp) != "date,cherry,Banana" || resp["prev_cursor"] != nil {
+		t.Fatalf("previous page : %d %s", rr.Code, rr.Body.String())
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item?order=created_at_desc&cursor=&limit=2", "")
+	rr, resp = doRequest(t, router, "GET", "/api/test_item?order=created_at_desc&limit=2&cursor="+resp["next_cursor"].(string), "")
+	if rr.Code != http.StatusOK || names(resp) != "Banana,apple" {
+		t.Fatalf("date page : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "GET", "/api/test_item?cursor=foo", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid cursor : expected 400 got %d", rr.Code)
+	}
+}
+
+func TestSort(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	for url, expected := range map[string]string{
+		"/api/test_item?sort=status,-name":    "date,cherry,Banana,apple",
+		"/api/test_item?sort=name":            "apple,Banana,cherry,date",
+		"/api/test_item?order=price_desc":     "date,cherry,Banana,apple",
+		"/api/test_item?order=name_long_desc": "apple,Banana,cherry,date",
+	} {
+		rr, resp := doRequest(t, router, "GET", url, "")
+		if rr.Code != http.StatusOK || names(resp) != expected {
+			t.Errorf("%s : %d expected %s got %s", url, rr.Code, expected, names(resp))
+		}
+	}
+	_, resp := doRequest(t, router, "GET", "/api/test_item?sort=status,-name&cursor=&limit=3", "")
+	rr, resp := doRequest(t, router, "GET", "/api/test_item?sort=status,-name&limit=3&cursor="+resp["next_cursor"].(string), "")
+	if rr.Code != http.StatusOK || names(resp) != "apple" {
+		t.Errorf("cursor : %d %s", rr.Code, rr.Body.String())
+	}
+	for _, url := range []string{"/api/test_item?sort=nam", "/api/test_item?sort=name,-name", "/api/test_item?sort=note"} {
+		if rr, _ := doRequest(t, router, "GET", url, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s : expected 400 got %d", url, rr.Code)
+		}
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	rr, resp := doRequest(t, router, "GET", "/api/test_item?sort=name&fields=name,owner.name", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list : %d %s", rr.Code, rr.Body.String())
+	}
+	first := resp["data"].([]interface{})[0].(map[string]interface{})
+	if len(first) != 2 || first["Name"] != "apple" || first["Owner"].(map[string]interface{})["Name"] != "bob" || len(first["Owner"].(map[string]interface{})) != 1 {
+		t.Errorf("list : unexpected item %v", first)
+	}
+	rr, resp = doRequest(t, router, "GET", "/api/test_item/1?fields=price", "")
+	if item := resp["data"].(map[string]interface{}); rr.Code != http.StatusOK || len(item) != 1 || item["Price"] != 5.0 {
+		t.Errorf("get : %d %s", rr.Code, rr.Body.String())
+	}
+	for _, url := range []string{"/api/test_item?fields=status", "/api/test_item?fields=owner.email", "/api/test_item/1?fields=secret"} {
+		if rr, _ := doRequest(t, router, "GET", url, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s : expected 400 got %d", url, rr.Code)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	rr, resp := doRequestHeaders(t, router, "GET", "/api/test_item/1?include=owner", "", authHeader(1, 4))
+	if rr.Code != http.StatusOK || resp["data"].(map[string]interface{})["Owner"] == nil {
+		t.Errorf("get : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "GET", "/api/test_item/1", "")
+	if rr.Code != http.StatusOK || resp["data"].(map[string]interface{})["Owner"] != nil {
+		t.Errorf("get without include : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequestHeaders(t, router, "GET", "/api/test_item?sort=name&fields=name&include=owner", "", authHeader(1, 4))
+	if first := resp["data"].([]interface{})[0].(map[string]interface{}); rr.Code != http.StatusOK || first["Owner"] == nil || len(first) != 2 {
+		t.Errorf("list : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "GET", "/api/test_item?include=owner", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("include without rights : expected 403 got %d", rr.Code)
+	}
+	for _, url := range []string{"/api/test_item?include=items", "/api/test_item?include=owner.company.a.b"} {
+		if rr, _ := doRequest(t, router, "GET", url, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s : expected 400 got %d", url, rr.Code)
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	rr, resp := doRequestHeaders(t, router, "PATCH", "/api/test_item/1", `{"Price": 0, "Note": null, "Status": ""}`, map[string]string{"Content-Type": "application/merge-patch+json"})
+	item, _ := resp["data"].(map[string]interface{})
+	if rr.Code != http.StatusOK || item["Price"] != 0.0 || item["Note"] != nil || item["Status"] != "" || item["Name"] != "apple" {
+		t.Fatalf("merge patch : %d %s", rr.Code, rr.Body.String())
+	}
+	if history := item["History"].([]interface{}); len(history) != 2 || history[0].(map[string]interface{})["field"] != "price" || history[1].(map[string]interface{})["oldJSON"] != "note" {
+		t.Errorf("merge patch history : %v", item["History"])
+	}
+	stored := TestItem{}
+	GetDB().First(&stored, 1)
+	if stored.Price != 0 || stored.Note != nil || stored.Name != "apple" {
+		t.Errorf("merge patch not saved : %+v", stored)
+	}
+
+	patch := `[{"op": "test", "path": "/Name", "value": "apple"}, {"op": "replace", "path": "/Name", "value": "apricot"}, {"op": "copy", "from": "/Name", "path": "/Status"}]`
+	rr, resp = doRequestHeaders(t, router, "PATCH", "/api/test_item/1", patch, map[string]string{"Content-Type": "application/json-patch+json"})
+	if item := resp["data"].(map[string]interface{}); rr.Code != http.StatusOK || item["Name"] != "apricot" || item["Status"] != "apricot" {
+		t.Fatalf("json patch : %d %s", rr.Code, rr.Body.String())
+	}
+	for patch, code := range map[string]int{
+		`[{"op": "test", "path": "/Name", "value": "apple"}]`: http.StatusConflict,
+		`[{"op": "remove", "path": "/Unknown"}]`:              http.StatusUnprocessableEntity,
+		`[{"op": "replace", "path": "/Name", "value": ""}]`:   http.StatusUnprocessableEntity,
+		`{"op": "add"}`: http.StatusBadRequest,
+	} {
+		if rr, _ := doRequestHeaders(t, router, "PATCH", "/api/test_item/1", patch, map[string]string{"Content-Type": "application/json-patch+json"}); rr.Code != code {
+			t.Errorf("%s : expected %d got %d", patch, code, rr.Code)
+		}
+	}
+	if rr, _ := doRequestHeaders(t, router, "PATCH", "/api/test_item/1", "<a/>", map[string]string{"Content-Type": "application/xml"}); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("xml patch : expected 415 got %d", rr.Code)
+	}
+
+	//the key and the version can't be patched
+	for _, v := range []struct{ contentType, patch string }{
+		{"application/merge-patch+json", `{"ID": null, "Version": null, "Name": "kiwi"}`},
+		{"application/json-patch+json", `[{"op": "replace", "path": "/ID", "value": 2}, {"op": "replace", "path": "/Version", "value": 9}]`},
+		{"application/json-patch+json", `[{"op": "remove", "path": "/ID"}, {"op": "replace", "path": "/Name", "value": "lime"}]`},
+	} {
+		if rr, _ := doRequestHeaders(t, router, "PATCH", "/api/test_item/3", v.patch, map[string]string{"Content-Type": v.contentType}); rr.Code != http.StatusOK {
+			t.Errorf("%s : %d %s", v.patch, rr.Code, rr.Body.String())
+		}
+	}
+	count, patched, other := int64(0), TestItem{}, TestItem{}
+	GetDB().Model(&TestItem{}).Count(&count)
+	GetDB().First(&patched, 3)
+	GetDB().First(&other, 2)
+	if count != 4 || patched.Name != "lime" || patched.Version != 3 || other.Name != "Banana" {
+		t.Errorf("patched key : %d %+v %+v", count, patched, other)
+	}
+}
+
+func TestETag(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	rr, _ := doRequest(t, router, "GET", "/api/test_item/2", "")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag != `"v0"` {
+		t.Fatalf("get etag : %d %q", rr.Code, etag)
+	}
+	if rr, _ := doRequest(t, router, "GET", "/api/test_item/2?fields=name", ""); !strings.HasPrefix(rr.Header().Get("ETag"), `W/"`) {
+		t.Errorf("etag with fields : %q", rr.Header().Get("ETag"))
+	}
+	rr, resp := doRequestHeaders(t, router, "PUT", "/api/test_item/2", `{"Price": 13}`, map[string]string{"If-Match": `"v9", ` + etag})
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"v1"` || resp["data"].(map[string]interface{})["Version"] != 1.0 {
+		t.Fatalf("put if-match : %d %q %s", rr.Code, rr.Header().Get("ETag"), rr.Body.String())
+	}
+	for _, v := range []struct{ method, body string }{{"PUT", `{"Price": 14}`}, {"PATCH", `{"Price": 14}`}, {"DELETE", ""}} {
+		if rr, _ := doRequestHeaders(t, router, v.method, "/api/test_item/2", v.body, map[string]string{"If-Match": etag, "Content-Type": "application/merge-patch+json"}); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s stale if-match : expected 412 got %d", v.method, rr.Code)
+		}
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_item/2", `{"Price": 14, "Version": 5}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("put stale version : expected 412 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "DELETE", "/api/test_item/2", "", map[string]string{"If-Match": `W/"v1"`}); rr.Code != http.StatusOK {
+		t.Errorf("delete if-match : %d %s", rr.Code, rr.Body.String())
+	}
+
+	a, b := ETag(&TestObject{Name: "a"}), ETag(&TestObject{Name: "b"})
+	if a == "" || a == b || a != ETag(&TestObject{Name: "a"}) {
+		t.Errorf("hash etag : %s %s", a, b)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes().WithCacheControl("private, max-age=60"))
+	rr, _ := doRequest(t, router, "GET", "/api/test_item/1", "")
+	modified := rr.Header().Get("Last-Modified")
+	if rr.Code != http.StatusOK || modified == "" || rr.Header().Get("Cache-Control") != "private, max-age=60" || rr.Header().Get("Vary") != "Accept, Authorization" {
+		t.Fatalf("get headers : %d %v", rr.Code, rr.Header())
+	}
+	for _, headers := range []map[string]string{{"If-None-Match": rr.Header().Get("ETag")}, {"If-None-Match": "*"}, {"If-Modified-Since": modified}} {
+		if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item/1", "", headers); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Errorf("%v : expected 304 got %d", headers, rr.Code)
+		}
+	}
+	for _, headers := range []map[string]string{{"If-None-Match": `"v9"`}, {"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT"}} {
+		if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item/1", "", headers); rr.Code != http.StatusOK {
+			t.Errorf("%v : expected 200 got %d", headers, rr.Code)
+		}
+	}
+
+	rr, _ = doRequest(t, router, "GET", "/api/test_item?sort=name", "")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) || rr.Header().Get("Last-Modified") != "" {
+		t.Fatalf("list headers : %d %v", rr.Code, rr.Header())
+	}
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item?sort=name", "", map[string]string{"If-Modified-Since": modified}); rr.Code != http.StatusOK {
+		t.Errorf("list if-modified-since : expected 200 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item?sort=name", "", map[string]string{"If-None-Match": etag}); rr.Code != http.StatusNotModified {
+		t.Errorf("list if-none-match : expected 304 got %d", rr.Code)
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_item/2", `{"Price": 13}`); rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "" {
+		t.Errorf("put : %d %v", rr.Code, rr.Header())
+	}
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item?sort=name", "", map[string]string{"If-None-Match": etag}); rr.Code != http.StatusOK {
+		t.Errorf("list modified : expected 200 got %d", rr.Code)
+	}
+
+	//the included associations are in the tag of the response
+	included := authHeader(1, 4)
+	rr, _ = doRequestHeaders(t, router, "GET", "/api/test_item/1?include=owner", "", included)
+	if etag = rr.Header().Get("ETag"); rr.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) || rr.Header().Get("Last-Modified") != "" {
+		t.Fatalf("include headers : %d %v", rr.Code, rr.Header())
+	}
+	included["If-None-Match"] = etag
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item/1?include=owner", "", included); rr.Code != http.StatusNotModified {
+		t.Errorf("include if-none-match : expected 304 got %d", rr.Code)
+	}
+	GetDB().Model(&TestOwner{}).Where("id = ?", 1).Update("name", "carol")
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item/1?include=owner", "", included); rr.Code != http.StatusOK {
+		t.Errorf("include modified : expected 200 got %d", rr.Code)
+	}
+	delete(included, "If-None-Match")
+	included["If-Modified-Since"] = modified
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item/1?include=owner", "", included); rr.Code != http.StatusOK {
+		t.Errorf("include if-modified-since : expected 200 got %d", rr.Code)
+	}
+
+	//the policy is set by the handler, without the authentication middleware
+	routes := testItemRoutes().WithCacheControl("no-cache").WithCacheControl("public, max-age=5", "GetAllTest_item")
+	rr = httptest.NewRecorder()
+	routes.Get("GetAllTest_item").HandlerFunc(rr, httptest.NewRequest("GET", "/api/test_item", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "public, max-age=5" {
+		t.Errorf("handler cache-control : %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestBulk(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	count := func() int64 {
+		c := int64(0)
+		GetDB().Model(&TestItem{}).Count(&c)
+		return c
+	}
+	statuses := func(resp map[string]interface{}) []interface{} {
+		ret := []interface{}{}
+		items, ok := resp["data"].([]interface{})
+		if errors, isProblem := resp["errors"].(map[string]interface{}); !ok && isProblem { //atomic failures are problems
+			items, _ = errors["items"].([]interface{})
+		}
+		for _, v := range items {
+			ret = append(ret, v.(map[string]interface{})["status"])
+		}
+		return ret
+	}
+	body := `[{"Name": "egg", "Price": 1}, {"Name": ""}]`
+	rr, resp := doRequest(t, router, "POST", "/api/test_item/bulk", body)
+	if rr.Code != http.StatusUnprocessableEntity || fmt.Sprint(statuses(resp)) != "[424 422]" || count() != 4 {
+		t.Fatalf("atomic create : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "POST", "/api/test_item/bulk?mode=partial", body)
+	if rr.Code != http.StatusMultiStatus || fmt.Sprint(statuses(resp)) != "[201 422]" || count() != 5 {
+		t.Fatalf("partial create : %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr, resp = doRequest(t, router, "PATCH", "/api/test_item/bulk", `[{"ID": 1, "Price": 7}, {"ID": 2, "Note": "ripe"}]`)
+	if rr.Code != http.StatusOK || fmt.Sprint(statuses(resp)) != "[200 200]" {
+		t.Fatalf("atomic update : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "PATCH", "/api/test_item/bulk?mode=partial", `[{"ID": 1, "Price": 0}, {"ID": 99}, {"Price": 1}]`)
+	if rr.Code != http.StatusMultiStatus || fmt.Sprint(statuses(resp)) != "[200 404 400]" {
+		t.Fatalf("partial update : %d %s", rr.Code, rr.Body.String())
+	}
+	stored := TestItem{}
+	if GetDB().First(&stored, 1); stored.Price != 0 || stored.Version != 2 {
+		t.Errorf("update not saved : %+v", stored)
+	}
+
+	xml := `<items><item><Name>kiwi</Name><Price>2</Price></item><item><Name>lime</Name></item></items>`
+	rr, resp = doRequestHeaders(t, router, "POST", "/api/test_item/bulk", xml, map[string]string{"Content-Type": "application/xml"})
+	if rr.Code != http.StatusOK || fmt.Sprint(statuses(resp)) != "[201 201]" || count() != 7 {
+		t.Fatalf("xml create : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_item/bulk", "Name\nfig", map[string]string{"Content-Type": "text/csv"}); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("csv create : expected 415 got %d", rr.Code)
+	}
+
+	rr, resp = doRequest(t, router, "DELETE", "/api/test_item?id=3,99", "")
+	if rr.Code != http.StatusUnprocessableEntity || fmt.Sprint(statuses(resp)) != "[424 404]" || count() != 7 {
+		t.Fatalf("atomic delete : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "DELETE", "/api/test_item?id=3,4", ""); rr.Code != http.StatusOK || count() != 5 {
+		t.Errorf("delete : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "DELETE", "/api/test_item?id=1&mode=all", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid mode : expected 400 got %d", rr.Code)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	for _, id := range []string{"1", "3"} {
+		if rr, _ := doRequest(t, router, "DELETE", "/api/test_item/"+id, ""); rr.Code != http.StatusOK {
+			t.Fatalf("delete %s : %d %s", id, rr.Code, rr.Body.String())
+		}
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_item?sort=name", ""); names(resp) != "Banana,date" {
+		t.Errorf("list without trash : %s", names(resp))
+	}
+	if rr, _ := doRequest(t, router, "GET", "/api/test_item/trash", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("trash without token : expected 401 got %d", rr.Code)
+	}
+	if _, resp := doRequestHeaders(t, router, "GET", "/api/test_item/trash?sort=name", "", authHeader(1, 2)); names(resp) != "apple,cherry" {
+		t.Errorf("trash : %s", names(resp))
+	}
+	if err := EnableAudit(GetDB()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { AuditEnabled = false }()
+	defer RegisterHooks(&TestItem{}, Hooks{})
+	updates := 0
+	RegisterHooks(&TestItem{}, Hooks{
+		BeforeUpdate: func(r *http.Request, tx *gorm.DB, old interface{}, data interface{}) error {
+			if old.(*TestItem).DeletedAt.Valid && !data.(*TestItem).DeletedAt.Valid {
+				updates++
+			}
+			return nil
+		},
+		AfterUpdate: func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			updates++
+			return nil
+		},
+	})
+	rr, resp := doRequestHeaders(t, router, "POST", "/api/test_item/1/restore", "", authHeader(1, 2))
+	if item, _ := resp["data"].(map[string]interface{}); rr.Code != http.StatusOK || item["Version"] != 1.0 || rr.Header().Get("ETag") != `"v1"` || updates != 2 {
+		t.Fatalf("restore : %d %d %s", rr.Code, updates, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_item/1/history", ""); len(resp["data"].([]interface{})) != 1 || resp["data"].([]interface{})[0].(map[string]interface{})["action"] != AuditRestore {
+		t.Errorf("restore history : %v", resp["data"])
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_item/2/restore", "", authHeader(1, 2)); rr.Code != http.StatusConflict {
+		t.Errorf("restore not deleted : expected 409 got %d", rr.Code)
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_item?sort=name", ""); names(resp) != "apple,Banana,date" {
+		t.Errorf("list after restore : %s", names(resp))
+	}
+	if rr, _ := doRequestHeaders(t, router, "DELETE", "/api/test_item/3?hard=true", "", authHeader(1, 2)); rr.Code != http.StatusForbidden {
+		t.Errorf("hard delete without rights : expected 403 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "DELETE", "/api/test_item/3?hard=true", "", authHeader(1, 8)); rr.Code != http.StatusOK {
+		t.Errorf("hard delete : %d %s", rr.Code, rr.Body.String())
+	}
+	if c := int64(0); GetDB().Unscoped().Model(&TestItem{}).Count(&c).Error != nil || c != 3 {
+		t.Errorf("hard delete kept the row : %d", c)
+	}
+}
+
+//testDefaultRights a TestItem without the rights of the hard deletes
+type testDefaultRights struct {
+	TestItem
+}
+
+func (c *testDefaultRights) SoftDeleteRights() SoftDeleteRights {
+	return SoftDeleteRights{Trash: 2, Restore: 2}
+}
+
+func TestHardDeleteDefault(t *testing.T) {
+	router := setupTestItems(t, CrudRoutes(&testDefaultRights{},
+		DefaultQueryAll, utils.NoRight,
+		DefaultRightAccess, utils.NoRight,
+		DefaultRightAccess, utils.NoRight,
+		DefaultRightEdit, utils.NoRight,
+		DefaultRightAccess, utils.NoRight,
+	))
+	if rr, _ := doRequestHeaders(t, router, "DELETE", "/api/test_item/3?hard=true", "", authHeader(1, 0xff)); rr.Code != http.StatusForbidden {
+		t.Errorf("hard delete without Hard rights : expected 403 got %d", rr.Code)
+	}
+	if rr, _ := doRequest(t, router, "DELETE", "/api/test_item/3", ""); rr.Code != http.StatusOK {
+		t.Errorf("soft delete : %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAudit(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	if err := EnableAudit(GetDB()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { AuditEnabled = false }()
+	if rr, _ := doRequestHeaders(t, router, "PUT", "/api/test_item/1", `{"Price": 6, "Status": "closed"}`, authHeader(7, 0)); rr.Code != http.StatusOK {
+		t.Fatalf("put : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "PATCH", "/api/test_item/bulk", `[{"ID": 1, "Name": "apricot"}]`); rr.Code != http.StatusOK {
+		t.Fatalf("bulk patch : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequestHeaders(t, router, "DELETE", "/api/test_item/1", "", map[string]string{"X-Request-Id": "req-1"}); rr.Code != http.StatusOK {
+		t.Fatalf("delete : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp := doRequest(t, router, "GET", "/api/test_item/1/history", "")
+	entries, _ := resp["data"].([]interface{})
+	if rr.Code != http.StatusOK || len(entries) != 3 || resp["total_nb_values"] != 3.0 {
+		t.Fatalf("history : %d %s", rr.Code, rr.Body.String())
+	}
+	actions := []string{}
+	for _, v := range entries {
+		actions = append(actions, v.(map[string]interface{})["action"].(string))
+	}
+	if strings.Join(actions, ",") != "delete,update,update" {
+		t.Errorf("history actions : %v", actions)
+	}
+	deleted, updated := entries[0].(map[string]interface{}), entries[2].(map[string]interface{})
+	if deleted["trace_id"] != "req-1" || len(deleted["changes"].([]interface{})) != 4 {
+		t.Errorf("delete entry : %v", deleted)
+	}
+	span := mocktracer.New().StartSpan("audit")
+	req := httptest.NewRequest("GET", "/api/test_item/1", nil).WithContext(opentracing.ContextWithSpan(context.Background(), span))
+	if id := AuditTraceID(req); id == "" || id != utils.TraceID(req) {
+		t.Errorf("span trace id : %q", id)
+	}
+	changes := updated["changes"].([]interface{})
+	if updated["user_id"] != 7.0 || len(changes) != 1 || changes[0].(map[string]interface{})["newValue"] != "6" {
+		t.Errorf("update entry : %v", updated)
+	}
+	if rr, _ := doRequest(t, router, "POST", "/api/test_item", `{"Name": "fig", "Price": 3}`); rr.Code != http.StatusOK {
+		t.Fatalf("create : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_item/5/history", ""); resp["total_nb_values"] != 1.0 {
+		t.Errorf("create history : %v", resp)
+	}
+	if rr, _ := doRequest(t, router, "GET", "/api/test_item/99/history", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown history : expected 404 got %d", rr.Code)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	if err := EnableAudit(GetDB()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { AuditEnabled = false }()
+	for _, body := range []string{`{"Price": 6}`, `{"Name": "apricot", "Price": 7}`, `{"Name": "avocado"}`} {
+		if rr, _ := doRequest(t, router, "PUT", "/api/test_item/1", body); rr.Code != http.StatusOK {
+			t.Fatalf("put %s : %d %s", body, rr.Code, rr.Body.String())
+		}
+	}
+	rr, resp := doRequest(t, router, "POST", "/api/test_item/1/revert?to=1&preview=true", "")
+	item, _ := resp["data"].(map[string]interface{})
+	if rr.Code != http.StatusOK || item["Name"] != "apple" || item["Price"] != 6.0 {
+		t.Fatalf("preview : %d %s", rr.Code, rr.Body.String())
+	}
+	if stored := (TestItem{}); GetDB().First(&stored, 1).Error != nil || stored.Name != "avocado" {
+		t.Errorf("preview saved : %+v", stored)
+	}
+	rr, resp = doRequest(t, router, "POST", "/api/test_item/1/revert?to=1", "")
+	if item := resp["data"].(map[string]interface{}); rr.Code != http.StatusOK || item["Name"] != "apple" || item["Price"] != 6.0 || item["Version"] != 4.0 {
+		t.Fatalf("revert : %d %s", rr.Code, rr.Body.String())
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item/1/history", "")
+	if last := resp["data"].([]interface{})[0].(map[string]interface{}); last["action"] != AuditRevert || len(last["changes"].([]interface{})) != 2 {
+		t.Errorf("revert history : %v", last)
+	}
+	//reverting the revert
+	if _, resp := doRequest(t, router, "POST", "/api/test_item/1/revert?to=3", ""); resp["data"].(map[string]interface{})["Name"] != "avocado" {
+		t.Errorf("revert of revert : %v", resp)
+	}
+
+	//times and null values are reverted exactly
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_item/2", `{"Price": 13}`); rr.Code != http.StatusOK {
+		t.Fatalf("put : %d %s", rr.Code, rr.Body.String())
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item/2/history", "")
+	base := resp["data"].([]interface{})[0].(map[string]interface{})["id"]
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_item/2", `{"CreatedAt": "2022-05-06T07:08:09.5Z", "Note": "ripe"}`); rr.Code != http.StatusOK {
+		t.Fatalf("put time : %d %s", rr.Code, rr.Body.String())
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item/2/history", "")
+	entry := resp["data"].([]interface{})[0].(map[string]interface{})
+	changes := map[string]interface{}{}
+	for _, v := range entry["changes"].([]interface{}) {
+		changes[v.(map[string]interface{})["field"].(string)] = v.(map[string]interface{})["newValue"]
+	}
+	if changes["created_at"] != "2022-05-06T07:08:09.5Z" || changes["note"] != "ripe" {
+		t.Errorf("time history : %v", entry)
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_item/2", `{"CreatedAt": "2023-01-01T00:00:00Z"}`); rr.Code != http.StatusOK {
+		t.Fatalf("put time : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, _ = doRequest(t, router, "POST", fmt.Sprintf("/api/test_item/2/revert?to=%v", entry["id"]), "")
+	stored := TestItem{}
+	GetDB().First(&stored, 2)
+	if rr.Code != http.StatusOK || !stored.CreatedAt.Equal(time.Date(2022, 5, 6, 7, 8, 9, 5e8, time.UTC)) || stored.Note == nil || *stored.Note != "ripe" {
+		t.Errorf("revert time : %d %+v", rr.Code, stored)
+	}
+	rr, _ = doRequest(t, router, "POST", fmt.Sprintf("/api/test_item/2/revert?to=%v", base), "")
+	stored = TestItem{}
+	GetDB().First(&stored, 2)
+	if rr.Code != http.StatusOK || !stored.CreatedAt.Equal(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)) || stored.Note != nil || stored.Price != 13 {
+		t.Errorf("revert to null : %d %+v %s", rr.Code, stored, rr.Body.String())
+	}
+
+	for url, code := range map[string]int{
+		"/api/test_item/1/revert":       http.StatusBadRequest,
+		"/api/test_item/1/revert?to=99": http.StatusNotFound,
+		"/api/test_item/2/revert?to=1":  http.StatusNotFound,
+	} {
+		if rr, _ := doRequest(t, router, "POST", url, ""); rr.Code != code {
+			t.Errorf("%s : expected %d got %d", url, code, rr.Code)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	defer RegisterHooks(&TestItem{}, Hooks{})
+	calls := []string{}
+	RegisterHooks(&TestItem{}, Hooks{
+		BeforeCreate: func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			calls = append(calls, "BeforeCreate")
+			if data.(*TestItem).Price < 0 {
+				return NewHookError(http.StatusPaymentRequired, "negative price")
+			}
+			return nil
+		},
+		AfterCreate: func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			calls = append(calls, "AfterCreate")
+			if data.(*TestItem).Name == "rollback" {
+				return errors.New("failed")
+			}
+			return tx.Create(&TestOwner{Name: data.(*TestItem).Name}).Error
+		},
+		BeforeUpdate: func(r *http.Request, tx *gorm.DB, old interface{}, data interface{}) error {
+			calls = append(calls, "BeforeUpdate "+old.(*TestItem).Name+">"+data.(*TestItem).Name)
+			return nil
+		},
+		AfterUpdate: func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			calls = append(calls, "AfterUpdate")
+			return nil
+		},
+		BeforeDelete: func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			calls = append(calls, "BeforeDelete")
+			return NewHookError(http.StatusConflict, "item in use")
+		},
+		BeforeList: func(r *http.Request, req *gorm.DB) (*gorm.DB, error) {
+			return req.Where("status = ?", "open"), nil
+		},
+		AfterRead: func(r *http.Request, data interface{}) error {
+			data.(*TestItem).Status = strings.ToUpper(data.(*TestItem).Status)
+			return nil
+		},
+	})
+	owners := func() int64 {
+		c := int64(0)
+		GetDB().Model(&TestOwner{}).Count(&c)
+		return c
+	}
+	if rr, _ := doRequest(t, router, "POST", "/api/test_item", `{"Name": "fig", "Price": 3}`); rr.Code != http.StatusOK || owners() != 2 {
+		t.Fatalf("create : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "POST", "/api/test_item", `{"Name": "fig", "Price": -3}`); rr.Code != http.StatusPaymentRequired {
+		t.Errorf("before create error : expected 402 got %d", rr.Code)
+	}
+	if rr, _ := doRequest(t, router, "POST", "/api/test_item", `{"Name": "rollback", "Price": 3}`); rr.Code != http.StatusInternalServerError || owners() != 2 {
+		t.Errorf("after create error : %d", rr.Code)
+	}
+	rolledBack := int64(0)
+	if GetDB().Model(&TestItem{}).Where("name = ?", "rollback").Count(&rolledBack); rolledBack != 0 {
+		t.Errorf("after create error not rolled back")
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_item/1", `{"Name": "apricot"}`); rr.Code != http.StatusOK {
+		t.Errorf("update : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "DELETE", "/api/test_item/1", ""); rr.Code != http.StatusConflict {
+		t.Errorf("before delete error : expected 409 got %d", rr.Code)
+	}
+	expected := "BeforeCreate,AfterCreate,BeforeCreate,BeforeCreate,AfterCreate,BeforeUpdate apple>apricot,AfterUpdate,BeforeDelete"
+	if strings.Join(calls, ",") != expected {
+		t.Errorf("calls : %v", calls)
+	}
+	_, resp := doRequest(t, router, "GET", "/api/test_item?sort=name", "")
+	if names(resp) != "apricot,Banana" || resp["data"].([]interface{})[0].(map[string]interface{})["Status"] != "OPEN" {
+		t.Errorf("list hooks : %v", resp)
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_item/3", ""); resp["data"].(map[string]interface{})["Status"] != "CLOSED" {
+		t.Errorf("read hook : %v", resp)
+	}
+}
+
+func TestCrudRoutesBaseline(t *testing.T) {
+	routes := CrudRoutes(&TestItem{},
+		DefaultQueryAll, utils.NoRight,
+		DefaultRightAccess, utils.NoRight,
+		DefaultRightAccess, utils.NoRight,
+		DefaultRightEdit, utils.NoRight,
+		DefaultRightAccess, utils.NoRight,
+	)
+	patterns := []string{}
+	for _, v := range routes {
+		patterns = append(patterns, v.Name+" "+v.Method+" "+v.Pattern)
+	}
+	expected := "GetAllTest_item GET /api/test_item,CreateTest_item POST /api/test_item,GetTest_item GET /api/test_item/{id:[0-9]+}," +
+		"UpdateTest_item PUT /api/test_item/{id:[0-9]+},DeleteTest_item DELETE /api/test_item/{id:[0-9]+}"
+	if strings.Join(patterns, ",") != expected {
+		t.Errorf("routes : %v", patterns)
+	}
+
+	router := setupTestItems(t, routes)
+	for _, v := range []struct{ method, url string }{{"POST", "/api/test_item/search"}, {"PATCH", "/api/test_item/1"}, {"GET", "/api/test_item/export"}, {"POST", "/api/test_item/import"}} {
+		if rr, _ := doRequest(t, router, v.method, v.url, ""); rr.Code != http.StatusNotFound && rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s not enabled : expected 404 or 405 got %d", v.method, v.url, rr.Code)
+		}
+	}
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item", "", map[string]string{"Accept": "text/csv"}); rr.Code == http.StatusOK && strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("csv list without export : %s", rr.Body.String())
+	}
+}
+
+func TestResource(t *testing.T) {
+	routes := Resource(&TestItem{}).
+		List(func(r *http.Request, req *gorm.DB) *gorm.DB { return req.Where("status = ?", "closed") }, utils.NoRight).
+		Update(DefaultRightEdit, 2).
+		Enable(extraVerbs...).
+		Disable(Bulk, Trash).
+		Prefix("/v2/").
+		Action("publish", func(w http.ResponseWriter, r *http.Request, data Validation) {
+			item := data.(*TestItem)
+			GetDB().Model(item).Update("status", "published")
+			resp := utils.Message(true, "published")
+			resp["data"] = item
+			utils.Respond(w, resp)
+		}, 4).
+		Routes()
+	patterns := []string{}
+	for _, v := range routes {
+		patterns = append(patterns, v.Method+" "+v.Pattern)
+	}
+	//Get, Create and Delete are not set : no read, create, import, history nor restore
+	expected := "GET /api/v2/test_item,POST /api/v2/test_item/search,GET /api/v2/test_item/aggregate,GET /api/v2/test_item/export," +
+		"PUT /api/v2/test_item/{id:[0-9]+},PATCH /api/v2/test_item/{id:[0-9]+}," +
+		"POST /api/v2/test_item/{id:[0-9]+}/revert,POST /api/v2/test_item/{id:[0-9]+}/publish"
+	if strings.Join(patterns, ",") != expected {
+		t.Errorf("routes : %v", patterns)
+	}
+	if routes.Get("Publishv2Test_item").Authorization != 4 || routes.Get("Updatev2Test_item").Authorization != 2 {
+		t.Errorf("rights : %+v", routes)
+	}
+
+	router := setupTestItems(t, routes)
+	if _, resp := doRequest(t, router, "GET", "/api/v2/test_item?sort=name", ""); names(resp) != "cherry,date" {
+		t.Errorf("list : %s", names(resp))
+	}
+	if rr, _ := doRequestHeaders(t, router, "PUT", "/api/v2/test_item/1", `{"Price": 1}`, authHeader(1, 1)); rr.Code != http.StatusForbidden {
+		t.Errorf("update without rights : expected 403 got %d", rr.Code)
+	}
+	for _, v := range []struct{ method, url string }{{"GET", "/api/v2/test_item/1"}, {"DELETE", "/api/v2/test_item/1"}, {"POST", "/api/v2/test_item"}, {"POST", "/api/v2/test_item/import"}} {
+		if rr, _ := doRequest(t, router, v.method, v.url, ""); rr.Code != http.StatusNotFound && rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s not set : expected 404 or 405 got %d", v.method, v.url, rr.Code)
+		}
+	}
+	if rr, _ := doRequest(t, router, "POST", "/api/v2/test_item/1/publish", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("action without token : expected 401 got %d", rr.Code)
+	}
+	rr, resp := doRequestHeaders(t, router, "POST", "/api/v2/test_item/1/publish", "", authHeader(1, 4))
+	if rr.Code != http.StatusOK || resp["data"].(map[string]interface{})["Status"] != "published" {
+		t.Errorf("action : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/v2/test_item/99/publish", "", authHeader(1, 4)); rr.Code != http.StatusNotFound {
+		t.Errorf("action on unknown : expected 404 got %d", rr.Code)
+	}
+}
+
+func TestRouteHooks(t *testing.T) {
+	status := func(value string) Hooks {
+		return Hooks{AfterRead: func(r *http.Request, data interface{}) error {
+			data.(*TestItem).Status = value
+			return nil
+		}}
+	}
+	routes := testItemRoutes()
+	for _, v := range []string{"v1", "v2"} {
+		routes = append(routes, Resource(&TestItem{}).Prefix(v).Get(DefaultRightAccess, utils.NoRight).Hooks(status(v)).Routes()...)
+	}
+	router := setupTestItems(t, routes)
+	defer RegisterHooks(&TestItem{}, Hooks{})
+	RegisterHooks(&TestItem{}, status("default"))
+	for url, expected := range map[string]string{"/api/v1/test_item/1": "v1", "/api/v2/test_item/1": "v2", "/api/test_item/1": "default"} {
+		if _, resp := doRequest(t, router, "GET", url, ""); resp["data"].(map[string]interface{})["Status"] != expected {
+			t.Errorf("%s : expected %s got %v", url, expected, resp["data"])
+		}
+	}
+}
+
+func TestCRUD(t *testing.T) {
+	crud := NewCRUD[TestItem]().
+		Get(func(r *http.Request, item *TestItem) bool { return item.Status == "open" }, utils.NoRight).
+		Update(func(r *http.Request, old *TestItem, new *TestItem) bool { return new.Price >= old.Price }, utils.NoRight).
+		Disable(Delete)
+	router := setupTestItems(t, crud.Routes())
+	if rr, _ := doRequest(t, router, "GET", "/api/test_item/3", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("typed get rights : expected 403 got %d", rr.Code)
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_item/2", `{"Price": 10}`); rr.Code != http.StatusForbidden {
+		t.Errorf("typed update rights : expected 403 got %d", rr.Code)
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_item/2", `{"Price": 14}`); rr.Code != http.StatusOK {
+		t.Errorf("typed update : %d %s", rr.Code, rr.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/api/test_item?price[gte]=12&sort=-price&pagesize=2", nil)
+	page, err := crud.Find(r)
+	if err != nil || page.Total != 3 || len(page.Data) != 2 || page.Data[0].Name != "date" || page.Data[1].Price != 30 {
+		t.Fatalf("find : %v %+v", err, page)
+	}
+	if _, err := crud.Find(httptest.NewRequest("GET", "/api/test_item?sort=unknown", nil)); err == nil {
+		t.Errorf("find with invalid sort : expected an error")
+	}
+	item, err := crud.First(mux.SetURLVars(httptest.NewRequest("GET", "/api/test_item/2", nil), map[string]string{"id": "2"}))
+	if err != nil || item.Name != "Banana" || item.Price != 14 {
+		t.Errorf("first : %v %+v", err, item)
+	}
+
+	//typed parent, hooks and actions
+	reads := 0
+	typed := NewCRUD[TestItem]().
+		List(DefaultQueryAll, utils.NoRight).
+		Update(func(r *http.Request, old *TestItem, new *TestItem) bool { return true }, utils.NoRight)
+	nested := WithParent(typed.Prefix("v2"), "oid", "owner_id", func(r *http.Request, owner *TestOwner) bool {
+		return owner.Name != "alice"
+	}).Hooks(TypedHooks[*TestItem]{
+		BeforeUpdate: func(r *http.Request, tx *gorm.DB, old *TestItem, item *TestItem) error {
+			if item.Price < old.Price {
+				return NewHookError(http.StatusConflict, "price decrease")
+			}
+			return nil
+		},
+		AfterRead: func(r *http.Request, item *TestItem) error {
+			reads++
+			return nil
+		},
+	}).Action("label", func(w http.ResponseWriter, r *http.Request, item *TestItem) {
+		utils.Respond(w, utils.Message(true, item.Name))
+	}, utils.NoRight)
+	router = setupTestItems(t, nested.Routes())
+	if _, resp := doRequest(t, router, "GET", "/api/v2/test_owners/1/test_item", ""); names(resp) != "apple" || reads != 1 {
+		t.Errorf("typed parent list : %d %s", reads, names(resp))
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/v2/test_owners/1/test_item/1", `{"Price": 1}`); rr.Code != http.StatusConflict {
+		t.Errorf("typed hook : expected 409 got %d", rr.Code)
+	}
+	if rr, resp := doRequest(t, router, "POST", "/api/v2/test_owners/1/test_item/1/label", ""); rr.Code != http.StatusOK || resp["message"] != "apple" {
+		t.Errorf("typed action : %d %s", rr.Code, rr.Body.String())
+	}
+	alice := TestOwner{Name: "alice"}
+	GetDB().Create(&alice)
+	if rr, _ := doRequest(t, router, "GET", fmt.Sprintf("/api/v2/test_owners/%d/test_item", alice.ID), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("typed parent rights : expected 403 got %d", rr.Code)
+	}
+}
+
+//TestStock an object with a composite key of slugs
+type TestStock struct {
+	Org  string `gorm:"primaryKey"`
+	Code string `gorm:"primaryKey"`
+	Name string
+	Qty  int
+}
+
+func (c *TestStock) TableName() string {
+	return "test_stock"
+}
+
+func (c *TestStock) Validate() (map[string]interface{}, bool) {
+	return nil, true
+}
+
+func (c *TestStock) OrderColumns() []string {
+	return []string{"code"}
+}
+
+func (c *TestStock) FilterColumns() map[string]string {
+	return map[string]string{}
+}
+
+func (c *TestStock) FindFromRequest(r *http.Request) error {
+	return utils.DefaultFindFromRequest(r, GetDB(), c)
+}
+
+func (c *TestStock) QueryAllFromRequest(r *http.Request, q *gorm.DB) *gorm.DB {
+	return DefaultQueryAll(r, q)
+}
+
+func (c *TestStock) PrimaryKeys() []Key {
+	return []Key{{Name: "org", Column: "org", Pattern: KeySlug}, {Name: "code", Column: "code", Pattern: KeySlug}}
+}
+
+func TestCompositeKey(t *testing.T) {
+	routes := openResource(&TestStock{}).Routes()
+	if routes.Get("GetTest_stock").Pattern != "/api/test_stock/{org:"+KeySlug+"}/{code:"+KeySlug+"}" {
+		t.Errorf("pattern : %s", routes.Get("GetTest_stock").Pattern)
+	}
+	router := setupTestItems(t, routes)
+	GetDB().AutoMigrate(&TestStock{})
+	for _, v := range []TestStock{{"acme", "a-1", "bolt", 10}, {"acme", "bulk", "nut", 5}, {"other", "a-1", "gear", 1}} {
+		GetDB().Create(&v)
+	}
+
+	rr, resp := doRequest(t, router, "GET", "/api/test_stock/other/a-1", "")
+	if rr.Code != http.StatusOK || resp["data"].(map[string]interface{})["Name"] != "gear" {
+		t.Errorf("get : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "GET", "/api/test_stock/acme/a-2", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("get unknown : expected 404 got %d", rr.Code)
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_stock/acme/a-1", `{"Qty": 12}`); rr.Code != http.StatusOK {
+		t.Errorf("update : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "PATCH", "/api/test_stock/acme/bulk", `{"Qty": 6}`); rr.Code != http.StatusOK {
+		t.Errorf("patch on a slug like a static route : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "PATCH", "/api/test_stock/bulk", `[{"Org": "other", "Code": "a-1", "Qty": 2}, {"Org": "acme", "Qty": 1}]`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("bulk update without the whole key : expected 422 got %d", rr.Code)
+	}
+	if rr, _ := doRequest(t, router, "PATCH", "/api/test_stock/bulk", `[{"Org": "other", "Code": "a-1", "Qty": 2}]`); rr.Code != http.StatusOK {
+		t.Errorf("bulk update : %d %s", rr.Code, rr.Body.String())
+	}
+	stocks := []TestStock{}
+	GetDB().Order("org, code").Find(&stocks)
+	if len(stocks) != 3 || stocks[0].Qty != 12 || stocks[1].Qty != 6 || stocks[2].Qty != 2 {
+		t.Errorf("saved : %+v", stocks)
+	}
+	if rr, _ := doRequest(t, router, "DELETE", "/api/test_stock/acme/a-1", ""); rr.Code != http.StatusOK {
+		t.Errorf("delete : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "DELETE", "/api/test_stock?id=acme/bulk,other/a-1", ""); rr.Code != http.StatusOK {
+		t.Errorf("bulk delete : %d %s", rr.Code, rr.Body.String())
+	}
+	if GetDB().Find(&stocks); len(stocks) != 0 {
+		t.Errorf("deleted : %+v", stocks)
+	}
+}
+
+func (c *TestOwner) TableName() string {
+	return "test_owners"
+}
+
+func (c *TestOwner) Validate() (map[string]interface{}, bool) {
+	return nil, true
+}
+
+func (c *TestOwner) OrderColumns() []string {
+	return []string{}
+}
+
+func (c *TestOwner) FilterColumns() map[string]string {
+	return map[string]string{}
+}
+
+func (c *TestOwner) FindFromRequest(r *http.Request) error {
+	return utils.DefaultFindFromRequest(r, GetDB(), c)
+}
+
+func (c *TestOwner) QueryAllFromRequest(r *http.Request, q *gorm.DB) *gorm.DB {
+	return DefaultQueryAll(r, q)
+}
+
+func TestNestedResource(t *testing.T) {
+	routes := openResource(&TestItem{}).Parent(&TestOwner{}, "oid", "owner_id", func(r *http.Request, data interface{}) bool {
+		return data.(*TestOwner).Name != "alice" || utils.HasRightsRequest(r, 2)
+	}).Routes()
+	if routes.Get("GetAlltest_ownersTest_item").Pattern != "/api/test_owners/{oid:[0-9]+}/test_item" {
+		t.Errorf("routes : %+v", routes)
+	}
+	router := setupTestItems(t, routes)
+	alice := TestOwner{Name: "alice"}
+	GetDB().Create(&alice)
+	GetDB().Model(&TestItem{}).Where("id = ?", 2).Update("owner_id", alice.ID)
+
+	if _, resp := doRequest(t, router, "GET", "/api/test_owners/1/test_item", ""); names(resp) != "apple" {
+		t.Errorf("list of the parent : %s", names(resp))
+	}
+	if rr, _ := doRequest(t, router, "GET", "/api/test_owners/99/test_item", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown parent : expected 404 got %d", rr.Code)
+	}
+	if rr, _ := doRequest(t, router, "GET", "/api/test_owners/2/test_item", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("parent without rights : expected 403 got %d", rr.Code)
+	}
+	if _, resp := doRequestHeaders(t, router, "GET", "/api/test_owners/2/test_item", "", authHeader(1, 2)); names(resp) != "Banana" {
+		t.Errorf("list of the parent with rights : %s", names(resp))
+	}
+	for _, method := range []string{"GET", "PATCH", "DELETE"} {
+		if rr, _ := doRequest(t, router, method, "/api/test_owners/1/test_item/2", `{"Price": 1}`); rr.Code != http.StatusNotFound {
+			t.Errorf("%s of another parent : expected 404 got %d", method, rr.Code)
+		}
+	}
+	if rr, _ := doRequest(t, router, "PUT", "/api/test_owners/1/test_item/2", `{"Price": 1}`); rr.Code == http.StatusOK {
+		t.Errorf("update of another parent : %s", rr.Body.String())
+	}
+	if rr, _ := doRequest(t, router, "PATCH", "/api/test_owners/1/test_item/1", `{"OwnerID": 2}`); rr.Code != http.StatusOK {
+		t.Errorf("patch : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp := doRequest(t, router, "POST", "/api/test_owners/1/test_item", `{"Name": "fig", "OwnerID": 2}`)
+	if rr.Code != http.StatusOK || resp["data"].(map[string]interface{})["OwnerID"] != 1.0 {
+		t.Errorf("create : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_owners/1/test_item?sort=name", ""); names(resp) != "apple,fig" {
+		t.Errorf("list after create and patch : %s", names(resp))
+	}
+}
+
+//TestPost an object with a many to many association
+type TestPost struct {
+	ID     uint `gorm:"primarykey"`
+	Title  string
+	Secret string    `json:"-"`
+	Tags   []TestTag `gorm:"many2many:test_post_tags"`
+}
+
+//TestTag the associated objects of TestPost, a user only see the shared tags and its own
+type TestTag struct {
+	ID      uint `gorm:"primarykey"`
+	Name    string
+	OwnerID uint
+}
+
+func (c *TestTag) TableName() string {
+	return "test_tags"
+}
+
+func (c *TestTag) Validate() (map[string]interface{}, bool) {
+	return nil, true
+}
+
+func (c *TestTag) OrderColumns() []string {
+	return []string{}
+}
+
+func (c *TestTag) FilterColumns() map[string]string {
+	return map[string]string{}
+}
+
+func (c *TestTag) FindFromRequest(r *http.Request) error {
+	return utils.DefaultFindFromRequest(r, GetDB(), c)
+}
+
+func (c *TestTag) QueryAllFromRequest(r *http.Request, q *gorm.DB) *gorm.DB {
+	user, _ := r.Context().Value("user").(utils.Token)
+	return q.Where("owner_id IN ?", []uint{0, user.UserId})
+}
+
+func (c *TestPost) TableName() string {
+	return "test_post"
+}
+
+func (c *TestPost) Validate() (map[string]interface{}, bool) {
+	return nil, true
+}
+
+func (c *TestPost) OrderColumns() []string {
+	return []string{}
+}
+
+func (c *TestPost) FilterColumns() map[string]string {
+	return map[string]string{}
+}
+
+func (c *TestPost) FindFromRequest(r *http.Request) error {
+	return utils.DefaultFindFromRequest(r, GetDB(), c)
+}
+
+func (c *TestPost) QueryAllFromRequest(r *http.Request, q *gorm.DB) *gorm.DB {
+	return DefaultQueryAll(r, q)
+}
+
+func (c *TestPost) Associations() map[string]AssociationRights {
+	return map[string]AssociationRights{"tags": {Read: utils.NoRight, Write: 2, Access: func(r *http.Request, data interface{}) bool {
+		return data.(*TestTag).Name != "locked"
+	}}}
+}
+
+func TestAssociations(t *testing.T) {
+	routes := openResource(&TestPost{}).Routes()
+	if routes.Get("PutTagsTest_post").Pattern != "/api/test_post/{id:[0-9]+}/tags" || routes.Get("PutTagsTest_post").Authorization != 2 {
+		t.Errorf("routes : %+v", routes)
+	}
+	router := setupTestItems(t, routes)
+	GetDB().AutoMigrate(&TestPost{}, &TestTag{})
+	GetDB().Create(&TestPost{Title: "hello"})
+	for _, v := range []string{"go", "gorm", "mux", "sql"} {
+		GetDB().Create(&TestTag{Name: v})
+	}
+	tags := func(resp map[string]interface{}) string {
+		list := []string{}
+		for _, v := range resp["data"].([]interface{}) {
+			list = append(list, v.(map[string]interface{})["Name"].(string))
+		}
+		return strings.Join(list, ",")
+	}
+
+	if rr, _ := doRequest(t, router, "POST", "/api/test_post/1/tags", `[1, 2]`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("add without token : expected 401 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_post/1/tags", `[1, 99]`, authHeader(1, 2)); rr.Code != http.StatusNotFound {
+		t.Errorf("add unknown : expected 404 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_post/2/tags", `[1]`, authHeader(1, 2)); rr.Code != http.StatusNotFound {
+		t.Errorf("add to unknown : expected 404 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_post/1/tags", `[1, 2, 3]`, authHeader(1, 2)); rr.Code != http.StatusOK {
+		t.Errorf("add : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp := doRequest(t, router, "GET", "/api/test_post/1/tags?page=2&pagesize=2", "")
+	if rr.Code != http.StatusOK || tags(resp) != "mux" || resp["total_nb_values"] != 3.0 {
+		t.Errorf("list : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequestHeaders(t, router, "DELETE", "/api/test_post/1/tags?id=1,3", "", authHeader(1, 2)); rr.Code != http.StatusOK {
+		t.Errorf("remove : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_post/1/tags", ""); tags(resp) != "gorm" {
+		t.Errorf("list after remove : %s", tags(resp))
+	}
+	if rr, _ := doRequestHeaders(t, router, "PUT", "/api/test_post/1/tags", `[3, 4]`, authHeader(1, 2)); rr.Code != http.StatusOK {
+		t.Errorf("replace : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_post/1/tags", ""); tags(resp) != "mux,sql" {
+		t.Errorf("list after replace : %s", tags(resp))
+	}
+	if rr, _ := doRequestHeaders(t, router, "PUT", "/api/test_post/1/tags", `[]`, authHeader(1, 2)); rr.Code != http.StatusOK {
+		t.Errorf("clear : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_post/1/tags", ""); tags(resp) != "" {
+		t.Errorf("list after clear : %s", tags(resp))
+	}
+	if count := GetDB().Find(&[]TestTag{}).RowsAffected; count != 4 {
+		t.Errorf("tags should be kept : %d", count)
+	}
+
+	GetDB().Create(&TestTag{Name: "private", OwnerID: 2}) //5
+	GetDB().Create(&TestTag{Name: "locked"})              //6
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_post/1/tags", `[1, 5]`, authHeader(1, 2)); rr.Code != http.StatusNotFound {
+		t.Errorf("add a tag of another user : expected 404 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "PUT", "/api/test_post/1/tags", `[5]`, authHeader(2, 2)); rr.Code != http.StatusOK {
+		t.Errorf("replace by its own tag : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequestHeaders(t, router, "DELETE", "/api/test_post/1/tags?id=5", "", authHeader(1, 2)); rr.Code != http.StatusNotFound {
+		t.Errorf("remove a tag of another user : expected 404 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_post/1/tags", `[1, 6]`, authHeader(1, 2)); rr.Code != http.StatusForbidden {
+		t.Errorf("add a forbidden tag : expected 403 got %d", rr.Code)
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_post/1/tags", ""); tags(resp) != "private" {
+		t.Errorf("list after rejected edits : %s", tags(resp))
+	}
+}
+
+func TestExportHiddenFields(t *testing.T) {
+	router := setupTestItems(t, openResource(&TestPost{}).Routes())
+	GetDB().AutoMigrate(&TestPost{})
+	GetDB().Create(&TestPost{Title: "hello", Secret: "s3cret"})
+	rr, _ := doRequest(t, router, "GET", "/api/test_post/export?format=csv", "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "id,title\n1,hello\n" {
+		t.Errorf("export without json:\"-\" fields : %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	type row = map[string]interface{}
+	rows := func(resp map[string]interface{}) []row {
+		list := []row{}
+		for _, v := range resp["data"].([]interface{}) {
+			list = append(list, v.(map[string]interface{}))
+		}
+		return list
+	}
+
+	rr, resp := doRequest(t, router, "GET", "/api/test_item/aggregate?group=status&metrics=count,sum:price,max:price", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("aggregate : %d %s", rr.Code, rr.Body.String())
+	}
+	if res := rows(resp); len(res) != 2 || fmt.Sprint(res[0]) != "map[count:2 max_price:50 status:closed sum_price:80]" ||
+		fmt.Sprint(res[1]) != "map[count:2 max_price:12 status:open sum_price:17]" {
+		t.Errorf("aggregate by status : %v", res)
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item/aggregate?group=status&price[gte]=12", "")
+	if res := rows(resp); len(res) != 2 || res[0]["count"] != 2.0 || res[1]["count"] != 1.0 {
+		t.Errorf("aggregate with filters : %v", res)
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item/aggregate?metrics=avg:price,min:price", "")
+	if res := rows(resp); len(res) != 1 || res[0]["avg_price"] != 24.25 || res[0]["min_price"] != 5.0 {
+		t.Errorf("aggregate without group : %v", res)
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item/aggregate?group=created_at:month", "")
+	if res := rows(resp); len(res) != 1 || res[0]["created_at_month"] != "2021-01" || res[0]["count"] != 4.0 {
+		t.Errorf("aggregate by month : %v", res)
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item/aggregate?group=created_at:week,status", "")
+	if fmt.Sprint(rows(resp)) != "[map[count:1 created_at_week:2020-12-28 status:closed] map[count:2 created_at_week:2020-12-28 status:open] map[count:1 created_at_week:2021-01-04 status:closed]]" {
+		t.Errorf("aggregate by week : %v", rows(resp))
+	}
+	for _, query := range []string{"group=name", "metrics=sum:name", "metrics=median:price", "group=created_at:year"} {
+		if rr, _ := doRequest(t, router, "GET", "/api/test_item/aggregate?"+query, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s : expected 400 got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	rr, _ := doRequest(t, router, "GET", "/api/test_item/export?format=csv&status=open&sort=-price", "")
+	expected := "Name,Price,Status,Created,Note\nBanana,12,OPEN,2021-01-02 00:00:00,\napple,5,OPEN,2021-01-01 00:00:00,note\n"
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv" || rr.Body.String() != expected {
+		t.Errorf("csv : %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Content-Disposition") != "attachment; filename=test_item.csv" {
+		t.Errorf("csv filename : %s", rr.Header().Get("Content-Disposition"))
+	}
+	rr, _ = doRequestHeaders(t, router, "GET", "/api/test_item?price[gte]=30", "", map[string]string{"Accept": "text/csv"})
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "\n") != 3 || !strings.Contains(rr.Body.String(), "date,50,CLOSED") {
+		t.Errorf("csv accept : %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr, _ = doRequest(t, router, "GET", "/api/test_item/export?format=xlsx&name=cherry", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != exportMediaTypes[ExportXLSX] {
+		t.Fatalf("xlsx : %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	part := func(body *bytes.Buffer, name string) (string, int) {
+		z, err := zip.NewReader(bytes.NewReader(body.Bytes()), int64(body.Len()))
+		if err != nil {
+			t.Fatalf("xlsx zip : %v", err)
+		}
+		content := ""
+		for _, f := range z.File {
+			if f.Name == name {
+				rc, _ := f.Open()
+				b, _ := ioutil.ReadAll(rc)
+				rc.Close()
+				content = string(b)
+			}
+		}
+		return content, len(z.File)
+	}
+	sheet, files := part(rr.Body, "xl/worksheets/sheet1.xml")
+	if files != 5 || !strings.Contains(sheet, `<row><c t="inlineStr"><is><t xml:space="preserve">cherry</t></is></c><c><v>30</v></c>`) ||
+		strings.Count(sheet, "<row>") != 2 {
+		t.Errorf("xlsx sheet : %d files %s", files, sheet)
+	}
+
+	//the texts are not exported as formulas, except in the raw columns
+	GetDB().Model(&TestItem{}).Where("id = ?", 1).Updates(map[string]interface{}{"name": "=HYPERLINK(\"http://x\")", "note": "=1+1"})
+	GetDB().Model(&TestItem{}).Where("id = ?", 2).Update("name", "-2+3")
+	rr, _ = doRequest(t, router, "GET", "/api/test_item/export?format=csv&status=open&sort=price", "")
+	if expected := "Name,Price,Status,Created,Note\n\"'=HYPERLINK(\"\"http://x\"\")\",5,OPEN,2021-01-01 00:00:00,=1+1\n'-2+3,12,OPEN,2021-01-02 00:00:00,\n"; rr.Body.String() != expected {
+		t.Errorf("csv formulas : %q", rr.Body.String())
+	}
+	rr, _ = doRequest(t, router, "GET", "/api/test_item/export?format=xlsx&name=-2%2B3", "")
+	if sheet, _ := part(rr.Body, "xl/worksheets/sheet1.xml"); !strings.Contains(sheet, `<t xml:space="preserve">&#39;-2+3</t>`) {
+		t.Errorf("xlsx formulas : %s", sheet)
+	}
+	if workbook, _ := part(rr.Body, "xl/workbook.xml"); !strings.Contains(workbook, `<sheet name="test_item"`) {
+		t.Errorf("xlsx sheet name : %s", workbook)
+	}
+	if name := sheetName("a[b]:c*d?e/f\\g'" + strings.Repeat("é", 30)); name != "abcdefg'"+strings.Repeat("é", 23) {
+		t.Errorf("sheet name : %q", name)
+	}
+	if name := sheetName("'[]'"); name != "Sheet1" {
+		t.Errorf("empty sheet name : %q", name)
+	}
+
+	for _, query := range []string{"format=pdf", "sort=secret", "filter=nope"} {
+		if rr, _ := doRequest(t, router, "GET", "/api/test_item/export?"+query, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s : expected 400 got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	csvHeader := map[string]string{"Content-Type": "text/csv"}
+	actions := func(resp map[string]interface{}) string {
+		list := []string{}
+		for _, v := range resp["data"].([]interface{}) {
+			res := v.(map[string]interface{})
+			list = append(list, fmt.Sprintf("%v:%v", res["line"], res["action"]))
+		}
+		return strings.Join(list, ",")
+	}
+
+	body := "Name,Price,Status,Note\nfig,3,open,\n,4,open,\nkiwi,abc,open,\n\"grape, red\",2.5,closed,sweet\n"
+	rr, resp := doRequestHeaders(t, router, "POST", "/api/test_item/import?dry_run=true", body, csvHeader)
+	if rr.Code != http.StatusMultiStatus || actions(resp) != "2:created,3:rejected,4:rejected,5:created" {
+		t.Errorf("dry run : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_item?sort=name", ""); names(resp) != "apple,Banana,cherry,date" {
+		t.Errorf("dry run saved : %s", names(resp))
+	}
+	rr, resp = doRequestHeaders(t, router, "POST", "/api/test_item/import", body, csvHeader)
+	if rr.Code != http.StatusMultiStatus || actions(resp) != "2:created,3:rejected,4:rejected,5:created" {
+		t.Errorf("csv import : %d %s", rr.Code, rr.Body.String())
+	}
+	if res := resp["data"].([]interface{})[1].(map[string]interface{}); res["status"] != 422.0 || res["code"] != "validation_failed" || res["error"] != "Name is empty" {
+		t.Errorf("rejected row : %v", res)
+	}
+	_, resp = doRequest(t, router, "GET", "/api/test_item?name=grape,%20red", "")
+	if item := resp["data"].([]interface{})[0].(map[string]interface{}); item["Price"] != 2.5 || item["Note"] != "sweet" || item["Status"] != "closed" {
+		t.Errorf("csv imported : %v", item)
+	}
+
+	body = "{\"ID\": 1, \"Price\": 7}\n\n{\"ID\": 99, \"Name\": \"lime\"}\nnot json\n"
+	rr, resp = doRequestHeaders(t, router, "POST", "/api/test_item/import?mode=upsert", body, map[string]string{"Content-Type": "application/x-ndjson"})
+	if rr.Code != http.StatusMultiStatus || actions(resp) != "1:updated,3:created,4:rejected" {
+		t.Errorf("ndjson upsert : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_item/1", ""); resp["data"].(map[string]interface{})["Price"] != 7.0 ||
+		resp["data"].(map[string]interface{})["Name"] != "apple" {
+		t.Errorf("upserted : %v", resp["data"])
+	}
+	for _, query := range []string{"?dry_run=true", ""} {
+		rr, resp = doRequestHeaders(t, router, "POST", "/api/test_item/import"+query, "ID,Name\n2,banana\n", csvHeader)
+		if res := resp["data"].([]interface{})[0].(map[string]interface{}); rr.Code != http.StatusMultiStatus || res["status"] != 409.0 || res["code"] != "conflict" {
+			t.Errorf("insert of an existing key %s : %d %s", query, rr.Code, rr.Body.String())
+		}
+	}
+	if p := saveError(errors.New("UNIQUE constraint failed: test_item.id")); p.Status != http.StatusConflict {
+		t.Errorf("duplicate key : expected 409 got %d", p.Status)
+	}
+
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_item/import?mode=merge", "Name\nfig\n", csvHeader); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown mode : expected 400 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_item/import", `[{"Name": "fig"}]`, nil); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("json import : expected 415 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_item/import", "Secret\nx\n", csvHeader); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown column : expected 400 got %d", rr.Code)
+	}
+}
+
+func TestImportRights(t *testing.T) {
+	router := setupTestItems(t, Resource(&TestItem{}).Create(DefaultRightAccess, 1).Update(DefaultRightEdit, 2).Enable(Import).Routes())
+	body := "{\"ID\": 1, \"Price\": 7}\n{\"ID\": 99, \"Name\": \"lime\"}\n"
+	ndjson := func(rights utils.RightBits) map[string]string {
+		headers := authHeader(1, rights)
+		headers["Content-Type"] = "application/x-ndjson"
+		return headers
+	}
+	rr, resp := doRequestHeaders(t, router, "POST", "/api/test_item/import?mode=upsert", body, ndjson(1))
+	if res := resp["data"].([]interface{}); rr.Code != http.StatusMultiStatus || res[0].(map[string]interface{})["status"] != 403.0 ||
+		res[1].(map[string]interface{})["action"] != ImportCreated {
+		t.Errorf("upsert without update rights : %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_item/import?mode=upsert", body, ndjson(3)); rr.Code != http.StatusOK {
+		t.Errorf("upsert with update rights : %d %s", rr.Code, rr.Body.String())
+	}
+
+	routes := Resource(&TestItem{}).Create(DefaultRightAccess, utils.NoRight).Enable(Import).Routes() //without Update
+	rr, _ = doRequestHeaders(t, routes.Get("ImportTest_item").HandlerFunc, "POST", "/api/test_item/import?mode=upsert", body, ndjson(0))
+	if rr.Code != http.StatusMultiStatus || !strings.Contains(rr.Body.String(), `"status":403`) {
+		t.Errorf("upsert without update route : %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestNDJSONList(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	ndjson := map[string]string{"Accept": "application/x-ndjson"}
+	lines := func(body string) []map[string]interface{} {
+		list := []map[string]interface{}{}
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			item := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				t.Fatalf("line %q : %v", line, err)
+			}
+			list = append(list, item)
+		}
+		return list
+	}
+
+	rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item?sort=-price&pagesize=2", "", ndjson)
+	items := lines(rr.Body.String())
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" || len(items) != 4 ||
+		items[0]["Name"] != "date" || items[3]["Name"] != "apple" || strings.Contains(rr.Body.String(), "total_nb_values") {
+		t.Errorf("ndjson : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, _ = doRequestHeaders(t, router, "GET", "/api/test_item?status=open&fields=id,name", "", ndjson)
+	if items := lines(rr.Body.String()); len(items) != 2 || len(items[0]) != 2 || items[0]["Name"] == nil {
+		t.Errorf("ndjson with fields : %s", rr.Body.String())
+	}
+	rr, _ = doRequestHeaders(t, router, "POST", "/api/test_item/search", `{"price": {"gte": 30}}`, ndjson)
+	if items := lines(rr.Body.String()); len(items) != 2 {
+		t.Errorf("ndjson search : %s", rr.Body.String())
+	}
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item?include=owner", "", map[string]string{"Accept": "application/x-ndjson", "Authorization": authHeader(1, 4)["Authorization"]}); rr.Code != http.StatusBadRequest {
+		t.Errorf("ndjson include : expected 400 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item?fields=id,owner.name", "", map[string]string{"Accept": "application/x-ndjson", "Authorization": authHeader(1, 4)["Authorization"]}); rr.Code != http.StatusBadRequest {
+		t.Errorf("ndjson nested fields : expected 400 got %d", rr.Code)
+	}
+	rr, _ = doRequestHeaders(t, router, "GET", "/api/test_item?pagesize=0&page=0", "", ndjson)
+	if rr.Code != http.StatusOK || len(lines(rr.Body.String())) != 4 {
+		t.Errorf("ndjson without pagination : %d %s", rr.Code, rr.Body.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/api/test_item", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if strings.Contains(rr.Body.String(), "apple") {
+		t.Errorf("ndjson canceled : %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestProblems(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	problem := func(rr *httptest.ResponseRecorder, resp map[string]interface{}, status int, code string) bool {
+		return rr.Code == status && rr.Header().Get("Content-Type") == utils.ProblemMediaType && resp["code"] == code &&
+			resp["type"] == utils.ProblemTypeBase+code && resp["title"] == http.StatusText(status) && resp["status"] == float64(status)
+	}
+
+	rr, resp := doRequestHeaders(t, router, "GET", "/api/test_item/99", "", map[string]string{"X-Request-Id": "req-1"})
+	if !problem(rr, resp, http.StatusNotFound, utils.CodeNotFound) || resp["instance"] != "/api/test_item/99" || resp["trace_id"] != "req-1" {
+		t.Errorf("not found : %d %s %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "POST", "/api/test_item", `{"Name": ""}`)
+	if !problem(rr, resp, http.StatusUnprocessableEntity, utils.CodeValidationFailed) || resp["detail"] != "Name is empty" {
+		t.Errorf("validation : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "POST", "/api/test_item", `{"Name": `)
+	if !problem(rr, resp, http.StatusBadRequest, utils.CodeInvalidBody) {
+		t.Errorf("invalid body : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "GET", "/api/test_item?pagesize=0", "")
+	if !problem(rr, resp, http.StatusBadRequest, utils.CodeInvalidRequest) {
+		t.Errorf("pagesize 0 : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "GET", "/api/test_item?sort=unknown", "")
+	if !problem(rr, resp, http.StatusBadRequest, utils.CodeInvalidFilter) {
+		t.Errorf("invalid filter : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, resp = doRequest(t, router, "GET", "/api/test_item/trash", "")
+	if !problem(rr, resp, http.StatusUnauthorized, utils.CodeUnauthorized) || rr.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("no token : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, _ = doRequestHeaders(t, router, "GET", "/api/test_item/99", "", map[string]string{"Accept": "application/xml"})
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != "application/problem+xml" || !strings.Contains(rr.Body.String(), "not_found") {
+		t.Errorf("xml problem : %d %s %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	defer RegisterHooks(&TestItem{}, Hooks{})
+	RegisterHooks(&TestItem{}, Hooks{
+		BeforeCreate: func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			return &HookError{Status: http.StatusConflict, Message: "duplicate name", Code: "duplicate_name"}
+		},
+	})
+	rr, resp = doRequest(t, router, "POST", "/api/test_item", `{"Name": "fig", "Price": 1}`)
+	if !problem(rr, resp, http.StatusConflict, "duplicate_name") || resp["detail"] != "duplicate name" {
+		t.Errorf("hook code : %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+//testCodec a codec of a fake media type writing the message only
+type testCodec struct{}
+
+func (testCodec) Encode(w io.Writer, v interface{}) error {
+	_, err := fmt.Fprint(w, v.(map[string]interface{})["message"])
+	return err
+}
+
+func (testCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	v.(*TestItem).Name = string(b)
+	return nil
+}
+
+func TestCodecs(t *testing.T) {
+	router := setupTestItems(t, testItemRoutes())
+	rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item/1", "", map[string]string{"Accept": "application/xml"})
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/xml" ||
+		!strings.Contains(rr.Body.String(), "<response><data><CreatedAt>2021-01-01T00:00:00Z</CreatedAt>") ||
+		!strings.Contains(rr.Body.String(), "<Name>apple</Name>") {
+		t.Errorf("xml : %d %s", rr.Code, rr.Body.String())
+	}
+	rr, _ = doRequestHeaders(t, router, "GET", "/api/test_item?sort=name", "", map[string]string{"Accept": "text/html, application/*;q=0.5"})
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("wildcard : %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr, _ := doRequestHeaders(t, router, "GET", "/api/test_item/1", "", map[string]string{"Accept": "application/msgpack"}); rr.Code != http.StatusNotAcceptable {
+		t.Errorf("unknown accept : expected 406 got %d", rr.Code)
+	}
+	if rr, _ := doRequestHeaders(t, router, "POST", "/api/test_item", `a,b`, map[string]string{"Content-Type": "text/csv"}); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("unknown content type : expected 415 got %d", rr.Code)
+	}
+	rr, resp := doRequestHeaders(t, router, "POST", "/api/test_item", `<item><Name>fig</Name><Price>3</Price></item>`, map[string]string{"Content-Type": "application/xml; charset=utf-8"})
+	if rr.Code != http.StatusOK || resp["data"].(map[string]interface{})["Name"] != "fig" || resp["data"].(map[string]interface{})["Price"] != 3.0 {
+		t.Errorf("xml body : %d %s", rr.Code, rr.Body.String())
+	}
+
+	//the data of a response is a body and a filter is read as its json form
+	xmlBody := map[string]string{"Content-Type": "application/xml", "Accept": "application/xml"}
+	rr, _ = doRequestHeaders(t, router, "GET", "/api/test_item/1", "", xmlBody)
+	body := rr.Body.String()
+	body = strings.Replace(body[strings.Index(body, "<data>"):strings.Index(body, "</data>")+7], "<Name>apple</Name>", "<Name>apricot</Name>", 1)
+	if rr, _ := doRequestHeaders(t, router, "PUT", "/api/test_item/1", body, xmlBody); rr.Code != http.StatusOK {
+		t.Errorf("xml put : %d %s", rr.Code, rr.Body.String())
+	}
+	stored := TestItem{}
+	GetDB().First(&stored, 1)
+	if stored.Name != "apricot" || stored.Price != 5 || stored.Note == nil || *stored.Note != "note" || !stored.CreatedAt.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("xml put : %+v", stored)
+	}
+	filter := `<filter><status><in><item>open</item><item>closed</item></in></status><price><gt>10</gt></price><name>an</name></filter>`
+	if rr, resp := doRequestHeaders(t, router, "POST", "/api/test_item/search", filter, map[string]string{"Content-Type": "application/xml"}); rr.Code != http.StatusOK || names(resp) != "Banana" {
+		t.Errorf("xml filter : %d %s", rr.Code, rr.Body.String())
+	}
+
+	utils.RegisterCodec("application/x-test", testCodec{})
+	rr, _ = doRequestHeaders(t, router, "POST", "/api/test_item", `grape`, map[string]string{"Content-Type": "application/x-test", "Accept": "application/x-test"})
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-test" || rr.Body.String() != "success" {
+		t.Errorf("registered codec : %d %s", rr.Code, rr.Body.String())
+	}
+	if _, resp := doRequest(t, router, "GET", "/api/test_item?name=grape", ""); names(resp) != "grape" {
+		t.Errorf("registered codec body : %s", names(resp))
+	}
+}
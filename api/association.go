@@ -0,0 +1,145 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//GenericAssociationList list with pagination the objects of a many to many association, f is the read rights function of the object
+func GenericAssociationList(w http.ResponseWriter, r *http.Request, data Validation, name string, f func(r *http.Request, data interface{}) bool) {
+	tmp, rel, ok := findAssociation(w, r, data, name, f)
+	if !ok {
+		return
+	}
+	offset, pagesize, _ := GetAllFromDb(r)
+	if pagesize <= 0 {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid page or pagesize"))
+		return
+	}
+	items := reflect.New(reflect.SliceOf(reflect.PtrTo(rel.FieldSchema.ModelType)))
+	db := GetDB().WithContext(r.Context())
+	count := db.Model(tmp).Association(rel.Name).Count()
+	order := rel.FieldSchema.Table + "." + rel.FieldSchema.PrioritizedPrimaryField.DBName
+	if err := db.Model(tmp).Order(order).Offset(offset).Limit(pagesize).Association(rel.Name).Find(items.Interface()); err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+		return
+	}
+	resp := utils.Message(true, "data returned")
+	resp["data"] = items.Elem().Interface()
+	resp["total_nb_values"] = count
+	resp["current_page"] = offset/pagesize + 1
+	resp["size_page"] = pagesize
+	utils.Respond(w, resp)
+}
+
+//GenericAssociationEdit add (POST), replace (PUT) or remove (DELETE) objects of a many to many association, f is the update rights function of the object
+//POST and PUT read the json array of the keys of the objects ([1, 2]), DELETE read ?id=1,2
+//the objects are loaded with the QueryAllFromRequest of their model and checked with the Access of the association
+func GenericAssociationEdit(w http.ResponseWriter, r *http.Request, data Validation, name string, f func(r *http.Request, data interface{}, data2 interface{}) bool) {
+	tmp, rel, ok := findAssociation(w, r, data, name, func(r *http.Request, data interface{}) bool {
+		return f(r, data, data)
+	})
+	if !ok {
+		return
+	}
+	keys := []interface{}{}
+	if r.Method == http.MethodDelete {
+		for _, v := range strings.Split(r.FormValue("id"), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				keys = append(keys, v)
+			}
+		}
+	} else if err := utils.ReadJSON(r, &keys); err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
+		return
+	}
+	if len(keys) > MaxBulkItems || (len(keys) == 0 && r.Method != http.MethodPut) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, fmt.Sprintf("Between 1 and %d keys expected", MaxBulkItems)))
+		return
+	}
+	items := reflect.New(reflect.SliceOf(reflect.PtrTo(rel.FieldSchema.ModelType)))
+	if len(keys) > 0 {
+		db := GetDB().WithContext(r.Context())
+		if related, ok := reflect.New(rel.FieldSchema.ModelType).Interface().(Validation); ok {
+			db = related.QueryAllFromRequest(r, db)
+		}
+		column := rel.FieldSchema.Table + "." + rel.FieldSchema.PrioritizedPrimaryField.DBName
+		if err := db.Where(column+" IN ?", keys).Find(items.Interface()).Error; err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+			return
+		}
+		unique := map[string]bool{}
+		for _, v := range keys {
+			unique[fmt.Sprint(v)] = true
+		}
+		if items.Elem().Len() != len(unique) { //unknown or out of the scope of the user
+			utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Associated object not found"))
+			return
+		}
+		if access := associationAccess(data, name); access != nil {
+			for i := 0; i < items.Elem().Len(); i++ {
+				if !access(r, items.Elem().Index(i).Interface()) {
+					utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+					return
+				}
+			}
+		}
+	}
+	err := GetDB().WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		association := tx.Model(tmp).Association(rel.Name)
+		switch r.Method {
+		case http.MethodPost:
+			return association.Append(items.Elem().Interface())
+		case http.MethodPut:
+			return association.Replace(items.Elem().Interface())
+		}
+		return association.Delete(items.Elem().Interface())
+	})
+	if err != nil {
+		respondSaveError(w, r, err)
+		return
+	}
+	resp := utils.Message(true, "success")
+	resp["data"] = items.Elem().Interface()
+	utils.Respond(w, resp)
+}
+
+//associationAccess return the rights function of the objects of the association name
+func associationAccess(data Validation, name string) func(r *http.Request, data interface{}) bool {
+	if v, ok := data.(Associable); ok {
+		return v.Associations()[name].Access
+	}
+	return nil
+}
+
+//findAssociation load the object of the request and return the many to many relationship name
+func findAssociation(w http.ResponseWriter, r *http.Request, data Validation, name string, f func(r *http.Request, data interface{}) bool) (Validation, *schema.Relationship, bool) {
+	sch, err := parseSchema(data)
+	var rel *schema.Relationship
+	if err == nil {
+		if path, err := associationPath(sch, []string{strings.ToLower(name)}); err == nil {
+			rel = sch.Relationships.Relations[path]
+		}
+	}
+	if rel == nil || rel.Type != schema.Many2Many || rel.FieldSchema.PrioritizedPrimaryField == nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Unknown association "+name))
+		return nil, nil, false
+	}
+	tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+	err = tmp.FindFromRequest(r)
+	if !f(r, tmp) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+		return nil, nil, false
+	}
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+		return nil, nil, false
+	}
+	return tmp, rel, true
+}
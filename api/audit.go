@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+)
+
+//Audit actions
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRevert  = "revert"
+	AuditRestore = "restore"
+)
+
+//AuditEnabled store the changes of the HistoryAble objects, set by EnableAudit
+var AuditEnabled = false
+
+//AuditTable name of the table of the audit entries
+var AuditTable = "audit_entries"
+
+//AuditTraceID return the trace id stored with the audit entries, by default utils.TraceID like the problems
+var AuditTraceID = func(r *http.Request) string {
+	return utils.TraceID(r)
+}
+
+//AuditEntry a create, update, delete, revert or restore of a HistoryAble object
+type AuditEntry struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	Resource  string     `gorm:"index:idx_audit_object" json:"resource"`
+	ObjectID  string     `gorm:"index:idx_audit_object" json:"object_id"`
+	Action    string     `json:"action"`
+	UserID    uint       `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Changes   utils.JSON `json:"changes"`
+	TraceID   string     `json:"trace_id,omitempty"`
+}
+
+//TableName of the audit entries
+func (c *AuditEntry) TableName() string {
+	return AuditTable
+}
+
+//EnableAudit create the audit table and enable the audit
+func EnableAudit(db *gorm.DB) error {
+	if err := db.AutoMigrate(&AuditEntry{}); err != nil {
+		return err
+	}
+	AuditEnabled = true
+	return nil
+}
+
+//GenericHistory list the audit entries of an object, the latest first, if the user can read the object
+func GenericHistory(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}) bool) {
+	tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+	err := tmp.FindFromRequest(utils.WithQueryScopes(r, unscoped)) //history of trashed objects too
+	if !f(r, tmp) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+		return
+	}
+	offset, pagesize, _ := GetAllFromDb(r)
+	if pagesize <= 0 {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid page or pagesize"))
+		return
+	}
+	req := GetDB().WithContext(r.Context()).Model(&AuditEntry{}).Where("resource = ? AND object_id = ?", tmp.TableName(), primaryKey(tmp))
+	count := int64(0)
+	entries := []AuditEntry{}
+	if err := req.Count(&count).Order("id DESC").Offset(offset).Limit(pagesize).Find(&entries).Error; err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+		return
+	}
+	resp := utils.Message(true, "data returned")
+	resp["data"] = entries
+	resp["total_nb_values"] = count
+	resp["current_page"] = offset/pagesize + 1
+	resp["size_page"] = pagesize
+	utils.Respond(w, resp)
+}
+
+//recordAudit store the audit entry of a HistoryAble data, changes are the difference of an update
+func recordAudit(tx *gorm.DB, r *http.Request, data Validation, action string, changes []map[string]interface{}) error {
+	obj, ok := data.(HistoryAble)
+	if !ok || !AuditEnabled {
+		return nil
+	}
+	if action == AuditCreate || action == AuditRestore || action == AuditDelete {
+		changes = historySnapshot(obj, action == AuditDelete)
+	}
+	if changes == nil {
+		changes = []map[string]interface{}{}
+	}
+	entry := AuditEntry{Resource: data.TableName(), ObjectID: primaryKey(data), Action: action, TraceID: AuditTraceID(r)}
+	if t, ok := utils.GetAuthenticatedToken(r); ok {
+		entry.UserID = t.UserId
+	}
+	if err := entry.Changes.New(changes); err != nil {
+		return err
+	}
+	return tx.Create(&entry).Error
+}
+
+//historySnapshot return the history fields as changes from (or to if deleted) empty values, a restore is a snapshot like a create
+func historySnapshot(data HistoryAble, deleted bool) []map[string]interface{} {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	fields := data.GetHistoryFields()
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	dif := []map[string]interface{}{}
+	for _, name := range names {
+		f := v.FieldByName(name)
+		if !f.IsValid() {
+			continue
+		}
+		value := historyValue(f)
+		raw, err := json.Marshal(f.Interface())
+		change := map[string]interface{}{"field": fields[name]}
+		if deleted {
+			change["newValue"], change["oldValue"] = "", value
+			if err == nil {
+				change["oldJSON"] = json.RawMessage(raw)
+			}
+		} else {
+			change["newValue"], change["oldValue"] = value, ""
+			if err == nil {
+				change["newJSON"] = json.RawMessage(raw)
+			}
+		}
+		dif = append(dif, change)
+	}
+	return dif
+}
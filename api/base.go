@@ -1,12 +1,14 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/loupzeur/go-crud-api/utils"
 	"github.com/opentracing/opentracing-go"
@@ -63,29 +65,14 @@ func CrudRoutesSpecificURL(models Validation,
 	crefunc func(r *http.Request, data interface{}) bool, crerights utils.RightBits,
 	updfunc func(r *http.Request, data interface{}, data2 interface{}) bool, updrights utils.RightBits,
 	delfunc func(r *http.Request, data interface{}) bool, delrights utils.RightBits, url string) utils.Routes {
-	parentName := strings.Split(url, "/")
-	return utils.Routes{
-		utils.Route{Name: "GetAll" + parentName[0] + strings.Title(models.TableName()), Method: "GET", Pattern: "/api/" + url + models.TableName(),
-			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
-				GenericGetQueryAll(w, r, models, freq)
-			}, Authorization: uint32(getallrights)},
-		utils.Route{Name: "Get" + parentName[0] + strings.Title(models.TableName()), Method: "GET", Pattern: "/api/" + url + models.TableName() + "/{id:[0-9]+}",
-			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
-				GenericGet(w, r, models, getfunc)
-			}, Authorization: uint32(getrights)},
-		utils.Route{Name: "Create" + parentName[0] + strings.Title(models.TableName()), Method: "POST", Pattern: "/api/" + url + models.TableName(),
-			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
-				GenericCreate(w, r, models, crefunc)
-			}, Authorization: uint32(crerights)},
-		utils.Route{Name: "Update" + parentName[0] + strings.Title(models.TableName()), Method: "PUT", Pattern: "/api/" + url + models.TableName() + "/{id:[0-9]+}",
-			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
-				GenericUpdate(w, r, models, updfunc)
-			}, Authorization: uint32(updrights)},
-		utils.Route{Name: "Delete" + parentName[0] + strings.Title(models.TableName()), Method: "DELETE", Pattern: "/api/" + url + models.TableName() + "/{id:[0-9]+}",
-			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
-				GenericDelete(w, r, models, delfunc)
-			}, Authorization: uint32(delrights)},
-	}
+	return Resource(models).
+		List(freq, getallrights).
+		Get(getfunc, getrights).
+		Create(crefunc, crerights).
+		Update(updfunc, updrights).
+		Delete(delfunc, delrights).
+		Prefix(url).
+		Routes()
 }
 
 //CrudRoutes Generate default CRUD route for object
@@ -120,70 +107,152 @@ func GetAllFromDb(r *http.Request) (int, int, string) {
 
 //GenericGetQueryAll return all elements with filters
 func GenericGetQueryAll(w http.ResponseWriter, r *http.Request, data Validation, freq func(r *http.Request, req *gorm.DB) *gorm.DB) {
+	filter, err := filterParam(r)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidFilter, err.Error()))
+		return
+	}
+	genericGetQueryAll(w, r, data, freq, filter)
+}
+
+//filterParam return the Filter of ?filter=
+func filterParam(r *http.Request) (Filter, error) {
+	if v := r.FormValue("filter"); v != "" {
+		return ParseFilter([]byte(v))
+	}
+	return Filter{}, nil
+}
+
+func genericGetQueryAll(w http.ResponseWriter, r *http.Request, data Validation, freq func(r *http.Request, req *gorm.DB) *gorm.DB, filter Filter) {
 	dtype := reflect.TypeOf(data)
 	pages := reflect.New(reflect.SliceOf(dtype)).Interface()
 	span, _ := opentracing.StartSpanFromContext(r.Context(), "GenericGetQueryAll") //opentracing.GlobalTracer().StartSpan("GenericGetQueryAll")
 	defer span.Finish()
 	//Limit and Pagination Part
 
-	offset, pagesize, order := GetAllFromDb(r)
-	err := error(nil)
-	if offset <= 0 && pagesize <= 0 {
+	stream := utils.ResponseMediaType(w) == utils.NDJSONMediaType //streamed lists are not paginated
+	offset, pagesize, _ := GetAllFromDb(r)
+	if pagesize <= 0 && !stream {
 		span.LogKV("warn", "error with elements size, can't define offset or pagesize")
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid page or pagesize"))
+		return
 	}
-	//Ordering Part
-	hasOrders := false //avoid sql injection on orders
-	for _, v := range data.OrderColumns() {
-		val := strings.Split(order, "_")
-		orderDirection := val[len(val)-1]
-		if len(val) >= 2 && strings.HasPrefix(order, v) && (orderDirection == "asc" || orderDirection == "desc") {
-			hasOrders = true
-			if strings.Contains(v, "date") || strings.Contains(v, "id_") { // doesn't work on date :/
-				order = v + " " + strings.ToUpper(orderDirection)
-			} else {
-				order = v + "*1," + v + " " + strings.ToUpper(orderDirection)
-			}
-			break
-		}
-	}
-	if !hasOrders {
-		order = ""
+	q, err := prepareList(r, data, freq, filter)
+	if err != nil {
+		respondListError(w, r, err)
+		return
 	}
-	req := data.QueryAllFromRequest(r, GetDB()).Model(data)
 
-	//Get Default Query
-	req = freq(r, req)
-
-	if order != "" {
-		req = req.Order(order)
+	if stream {
+		genericStreamList(w, r, data, q)
+		return
 	}
-
-	req = GetQuery(r, req, data.FilterColumns())
+	if _, ok := r.URL.Query()["cursor"]; ok {
+		genericGetKeyset(w, r, data, q.req, q.sortKeys, q.selection)
+		return
+	}
+	req := applySort(q.req, q.sortKeys)
 
 	//Execution request Part
-	count, resp, err := DefaultCountFunc(r, req)
-	if err != nil {
-		utils.Respond(w, utils.Message(false, "Error while retrieving data "))
-		span.LogKV("warn", "reference splut error"+err.Error())
-		log.Println("reference split error :", err.Error())
-		return
+	resp := utils.Message(true, "data returned")
+	if r.FormValue("count") != "false" { //count can be skipped on big tables
+		count := int64(0)
+		count, resp, err = DefaultCountFunc(r, req)
+		if err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+			span.LogKV("warn", "reference splut error"+err.Error())
+			log.Println("reference split error :", err.Error())
+			return
+		}
+		resp["total_nb_values"] = count
 	}
 
 	err = req.Offset(offset).Limit(pagesize).Find(pages).Error
 	if err != nil {
 
 		span.LogKV("warn", "error while retrieving date "+err.Error())
-		utils.Respond(w, utils.Message(false, "Error while retrieving data"))
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
 		return
 	}
 
-	resp["data"] = pages
-	resp["total_nb_values"] = count
+	if err = afterRead(r, data, pages); err != nil {
+		respondHookError(w, r, err)
+		return
+	}
+	resp["data"] = q.selection.Filter(pages)
 	resp["current_page"] = offset/pagesize + 1
 	resp["size_page"] = pagesize
+	if checkNotModified(w, r, responseETag(resp), time.Time{}) {
+		return
+	}
 	utils.Respond(w, resp)
 }
 
+//listQuery a list request with its sort and fields selection, ready to be paginated
+type listQuery struct {
+	req       *gorm.DB
+	sortKeys  []SortKey
+	selection *FieldSelection
+}
+
+//prepareList build the list request of r : sort, fields, includes, freq, BeforeList hook and filters
+func prepareList(r *http.Request, data Validation, freq func(r *http.Request, req *gorm.DB) *gorm.DB, filter Filter) (*listQuery, error) {
+	//Ordering Part
+	sortKeys, err := GetSort(r, data)
+	if err != nil {
+		return nil, err
+	}
+	selection, err := GetFieldSelection(r, data)
+	if err != nil {
+		return nil, err
+	}
+	if selection != nil { //sorted columns are needed by cursors
+		for _, k := range sortKeys {
+			if f := selection.schema.LookUpField(k.Column); f != nil {
+				selection.addColumn(f.DBName)
+			}
+		}
+	}
+	includes, err := GetIncludes(r, data)
+	if err != nil {
+		return nil, err
+	}
+	selection.includeLinks(includes)
+	req := selection.Scope(data.QueryAllFromRequest(r, GetDB()).Model(data))
+	req = IncludeScope(includes)(req)
+	if req, err = filteredList(r, data, req, freq, filter); err != nil {
+		return nil, err
+	}
+	return &listQuery{req: req, sortKeys: sortKeys, selection: selection}, nil
+}
+
+//filteredList apply on the list request req freq, the parent of a nested resource, the BeforeList hook and the filters
+func filteredList(r *http.Request, data Validation, req *gorm.DB, freq func(r *http.Request, req *gorm.DB) *gorm.DB, filter Filter) (*gorm.DB, error) {
+	//Get Default Query
+	req = parentOf(r).Scope(freq(r, req))
+	req, err := beforeList(r, data, req)
+	if err != nil {
+		return nil, err
+	}
+
+	req = GetQuery(r, req, data.FilterColumns())
+	req = filter.Apply(req, data.FilterColumns())
+	var filterErr *FilterError
+	if errors.As(req.Error, &filterErr) {
+		return nil, filterErr
+	}
+	return req, nil
+}
+
+//respondListError respond the status of a hook error, 403 on forbidden associations else 400
+func respondListError(w http.ResponseWriter, r *http.Request, err error) {
+	if _, ok := hookProblem(err); ok {
+		respondHookError(w, r, err)
+		return
+	}
+	respondIncludeError(w, r, err)
+}
+
 var DefaultCountFunc = func(r *http.Request, req *gorm.DB) (int64, map[string]interface{}, error) {
 	resp := utils.Message(true, "data returned")
 	count := int64(0)
@@ -193,34 +262,56 @@ var DefaultCountFunc = func(r *http.Request, req *gorm.DB) (int64, map[string]in
 }
 
 //GetQuery add url query to gormrequest
+//columns map a column to its filter mode ("in", "stringlike", "year" or equality)
+//and/or a comma separated list of allowed operators (ex: "gte,lt,null") used as column[operator]=value
+//an invalid operator or a column[operator] of a column not in columns is added as a *FilterError to req.Error
 func GetQuery(r *http.Request, req *gorm.DB, columns map[string]string) *gorm.DB {
 	//Additionnal Querying Part
 	urlvars := r.URL.Query()
 	//Remove useless parameters to avoid iterating over filters for nothing ^^
 	delete(urlvars, "page")
 	delete(urlvars, "order")
+	delete(urlvars, "sort")
 	delete(urlvars, "pagesize")
+	delete(urlvars, "filter")
+	delete(urlvars, "cursor")
+	delete(urlvars, "limit")
+	delete(urlvars, "count")
+	delete(urlvars, "fields")
+	delete(urlvars, "include")
+	delete(urlvars, "group")
+	delete(urlvars, "metrics")
+	delete(urlvars, "format")
 
 	if len(urlvars) > 0 {
 		for k, v := range columns {
 			if val, ok := urlvars[k]; ok {
-				switch v {
-				case "in":
-					DefaultQueryFilteringFunc(r, req, k, val)
-				case "stringlike":
-					req = req.Where(k+" LIKE ?", "%"+val[0]+"%")
-				case "year":
-					req = req.Where("YEAR("+k+") = ?", val[0])
+				//first declared mode is used for plain column=value filters
+				mode := strings.TrimSpace(strings.Split(v, ",")[0])
+				switch {
+				case mode == "in":
+					req = DefaultQueryFilteringFunc(r, req, k, val)
+				case k == "distinct" && mode != "stringlike" && mode != "year":
+					// :/
+					req = req.Where("? IN (select DISTINCT ?)", val[0], val[0])
 				default:
-					if k == "distinct" {
-						// :/
-						req = req.Where("? IN (select DISTINCT ?)", val[0], val[0])
-					} else {
-						req = req.Where(k+"=?", val[0])
-					}
+					req = req.Where(plainFilter(k, v, val))
 				}
 			}
 		}
+		//Operators part : column[operator]=value
+		for key, val := range urlvars {
+			k, op, ok := parseFilterKey(key)
+			if !ok {
+				continue
+			}
+			v, ok := columns[k]
+			if !ok {
+				req.AddError(&FilterError{Column: k, Operator: op, Reason: "column not filterable"})
+				continue
+			}
+			req = applyFilterOperator(req, k, op, v, val)
+		}
 	}
 	return req
 }
@@ -230,21 +321,51 @@ func GetQuery(r *http.Request, req *gorm.DB, columns map[string]string) *gorm.DB
 //GenericGet default controller for get
 func GenericGet(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}) bool) {
 	tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
-	err := GetFromID(r, tmp)
+	selection, err := GetFieldSelection(r, data)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidFilter, err.Error()))
+		return
+	}
+	includes, err := GetIncludes(r, data)
+	if err != nil {
+		respondIncludeError(w, r, err)
+		return
+	}
+	selection.includeLinks(includes)
+	if selection != nil {
+		r = utils.WithQueryScopes(r, selection.Scope)
+	}
+	if includes != nil {
+		r = utils.WithQueryScopes(r, IncludeScope(includes))
+	}
+	err = GetFromID(r, tmp)
 	if !f(r, tmp) {
-		utils.RespondCode(w, utils.Message(false, "Forbidden"), http.StatusForbidden)
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
 		return
 	}
 	if err != nil {
-		utils.RespondCode(w, utils.Message(false, "Not Found"), http.StatusNotFound)
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+		return
+	}
+	if err = afterRead(r, data, tmp); err != nil {
+		respondHookError(w, r, err)
 		return
 	}
 	resp := utils.Message(true, "success")
-	resp["data"] = tmp
+	resp["data"] = selection.Filter(tmp)
+	etag, modified := ETag(tmp), lastModified(tmp)
+	if selection != nil || includes != nil { //the object tag and date don't cover a partial object nor its associations
+		etag, modified = responseETag(resp), time.Time{}
+	}
+	if checkNotModified(w, r, etag, modified) {
+		return
+	}
 	utils.Respond(w, resp)
 }
 
+//setUserEmitter set the user of the token and the parent key of a nested resource
 func setUserEmitter(r *http.Request, data Validation) {
+	setParentKey(r, data)
 	if v, ok := data.(Authed); ok {
 		t, authed := utils.GetAuthenticatedToken(r)
 		if authed {
@@ -254,29 +375,33 @@ func setUserEmitter(r *http.Request, data Validation) {
 
 }
 
-//GenericCreate create a new object
+//GenericCreate create a new object, f[0] is the rights function
+//f[1] is called after the save, prefer the AfterCreate hook which run in the transaction
 func GenericCreate(w http.ResponseWriter, r *http.Request, data Validation, f ...func(r *http.Request, data interface{}) bool) {
 	tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
 
 	err := createFromJSONRequest(r, tmp)
 	if err != nil {
-		utils.RespondCode(w, utils.Message(false, "Error : "+err.Error()), http.StatusNotAcceptable)
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
 		return
 	}
 	setUserEmitter(r, tmp)
 	actions := len(f)
 	reason, ok := tmp.Validate()
 	if !ok {
-		utils.RespondCode(w, reason, http.StatusNotAcceptable)
+		utils.RespondError(w, r, utils.ValidationProblem(reason))
 		return
 	}
 
 	if actions > 0 && !f[0](r, tmp) {
-		utils.RespondCode(w, utils.Message(false, "Forbidden"), http.StatusForbidden)
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
 		return
 	}
-	if err = GetDB().Save(tmp).Error; err != nil {
-		utils.RespondCode(w, utils.Message(false, "Error saving"), http.StatusInternalServerError)
+	err = writeObject(r, AuditCreate, nil, tmp, nil, func(tx *gorm.DB, data Validation) error {
+		return tx.Save(data).Error
+	})
+	if err != nil {
+		respondSaveError(w, r, err)
 		return
 	}
 	if actions == 2 {
@@ -292,30 +417,44 @@ func GenericUpdate(w http.ResponseWriter, r *http.Request, data Validation, f fu
 	tmp1 := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
 	tmp2 := reflect.New(reflect.TypeOf(data).Elem()).Interface()
 
-	err := updateFromID(r, tmp1, tmp2)
+	err := tmp1.FindFromRequest(r)
+	if err == nil {
+		if err := utils.ReadJSON(r, tmp2); err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
+			return
+		}
+	}
+	matched := ifMatch(r, tmp1)
 	setUserEmitter(r, tmp1)
-	val, ret := tmp1.Validate()
-	if !ret {
-		utils.RespondCode(w, val, http.StatusNotAcceptable)
+	if !f(r, tmp1, tmp2) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
 		return
 	}
-	if !f(r, tmp1, tmp2) {
-		utils.RespondCode(w, utils.Message(false, "Forbidden"), http.StatusForbidden)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
 		return
 	}
-	if _, err := copy(tmp1, tmp2); err != nil {
-		utils.RespondCode(w, utils.Message(false, "Data Error"), http.StatusInternalServerError)
+	val, ret := tmp1.Validate()
+	if !ret {
+		utils.RespondError(w, r, utils.ValidationProblem(val))
 		return
 	}
-	if err != nil {
-		utils.RespondCode(w, utils.Message(false, "Not Found"), http.StatusNotFound)
+	old := cloneObject(tmp1)
+	changes, errCopy := copy(tmp1, tmp2)
+	if errCopy != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Data Error"))
+		return
+	}
+	if !matched {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusPreconditionFailed, utils.CodePreconditionFailed, "Precondition Failed"))
 		return
 	}
 	setUserEmitter(r, tmp1)
-	if err = GetDB().Save(tmp1).Error; err != nil {
-		utils.RespondCode(w, utils.Message(false, "Error saving"), http.StatusInternalServerError)
+	if err = writeObject(r, AuditUpdate, old, tmp1, changes, saveObject); err != nil {
+		respondSaveError(w, r, err)
 		return
 	}
+	w.Header().Set("ETag", ETag(tmp1))
 	resp := utils.Message(true, "success")
 	resp["data"] = tmp1
 	utils.Respond(w, resp)
@@ -325,18 +464,36 @@ func GenericUpdate(w http.ResponseWriter, r *http.Request, data Validation, f fu
 func GenericDelete(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}) bool) {
 	tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
 	//tmp := reflect.Zero(reflect.SliceOf(reflect.TypeOf(data))).Interface()
+	hard, allowed := hardDelete(r, data)
+	if !allowed {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+		return
+	}
+	if hard { //trashed objects can be removed too
+		r = utils.WithQueryScopes(r, unscoped)
+	}
 	err := deleteFromID(r, tmp)
 	setUserEmitter(r, tmp)
 	if !f(r, tmp) {
-		utils.RespondCode(w, utils.Message(false, "Forbidden"), http.StatusForbidden)
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
 		return
 	}
 	if err != nil {
-		utils.RespondCode(w, utils.Message(false, "Not Found"), http.StatusNotFound)
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+		return
+	}
+	if !ifMatch(r, tmp) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusPreconditionFailed, utils.CodePreconditionFailed, "Precondition Failed"))
 		return
 	}
-	if err = GetDB().Delete(tmp).Error; err != nil {
-		utils.RespondCode(w, utils.Message(false, "Error saving"), http.StatusInternalServerError)
+	err = writeObject(r, AuditDelete, nil, tmp, nil, func(tx *gorm.DB, data Validation) error {
+		if hard {
+			tx = tx.Unscoped()
+		}
+		return deleteObject(tx, data)
+	})
+	if err != nil {
+		respondSaveError(w, r, err)
 		return
 	}
 	utils.Respond(w, utils.Message(true, "Deletion successful"))
@@ -362,19 +519,13 @@ func GetFromID(r *http.Request, data Validation, preloads ...string) error {
 	return data.FindFromRequest(r)
 }
 
-func updateFromID(r *http.Request, data1 Validation, data2 interface{}) error {
-	err := data1.FindFromRequest(r)
-	if err != nil {
-		return err
-	}
-	if err := utils.ReadJSON(r, data2); err != nil {
-		return err
-	}
-	return nil
-}
-
 //some difference / copy stuff
 func copy(dst interface{}, src interface{}) ([]map[string]interface{}, error) {
+	return copyFields(dst, src, false)
+}
+
+//copyFields copy fields of src in dst, zero values only if zeros, and set the difference on HistoryAble dst
+func copyFields(dst interface{}, src interface{}, zeros bool) ([]map[string]interface{}, error) {
 	dstV := reflect.Indirect(reflect.ValueOf(dst))
 	srcV := reflect.Indirect(reflect.ValueOf(src))
 
@@ -394,67 +545,27 @@ func copy(dst interface{}, src interface{}) ([]map[string]interface{}, error) {
 		tName = obj.GetHistoryFields()
 	}
 
-	tf := func(r reflect.Value) string {
-		switch r.Kind() {
-		case reflect.Struct:
-			if strings.HasPrefix(r.Type().String(), "null.") {
-				ret := r.MethodByName("ValueOrZero").Call([]reflect.Value{})
-				tmp := ""
-				if len(ret) > 0 && ret[0].IsValid() {
-					tmp = fmt.Sprintf("%v", ret[0].Interface())
-					switch tmp {
-					case "false":
-						tmp = "non"
-					case "true":
-						tmp = "oui"
-					}
-				}
-				return tmp
-			} else {
-				return "Type inconnu : " + r.Type().String()
-			}
-		case reflect.Array:
-			fallthrough
-		case reflect.Slice:
-			ret := []string{}
-			for i := 0; i < r.Len(); i++ {
-				name := r.Index(i).FieldByName("Name")
-				if name.IsValid() {
-					ret = append(ret, fmt.Sprintf("%+v", name.Interface()))
-				} else {
-					ret = append(ret, "...")
-				}
-			}
-			return strings.Join(ret, ",")
-		case reflect.Ptr:
-			if r.Elem().IsValid() {
-				name := r.Elem().FieldByName("Name")
-				if name.IsValid() {
-					return fmt.Sprintf("%+v", name.Interface())
-				} else {
-					return "..."
-				}
-			}
-		default:
-			//log.Printf("Default global %s\n", r.Kind().String())
-		}
-		return fmt.Sprintf("%+v", r.Interface())
-	}
-
 	for i := 0; i < dstV.NumField(); i++ {
 		f := srcV.Field(i)
-		if !isZeroOfUnderlyingType(f.Interface()) {
+		if zeros || !isZeroOfUnderlyingType(f.Interface()) {
 			if isHistoryAble {
 				eName := srcV.Type().Field(i).Name
 				fName, fExist := tName[eName]
 				if fExist { //only append some usefull fields
-					nV := tf(srcV.Field(i))
-					oV := tf(dstV.Field(i))
-					if oV != nV {
-						dif = append(dif, map[string]interface{}{
+					nV := historyValue(srcV.Field(i))
+					oV := historyValue(dstV.Field(i))
+					nJ, nErr := json.Marshal(srcV.Field(i).Interface())
+					oJ, oErr := json.Marshal(dstV.Field(i).Interface())
+					if oV != nV || string(oJ) != string(nJ) {
+						change := map[string]interface{}{
 							"field":    fName,
 							"newValue": nV,
-							"oldValue": oV})
+							"oldValue": oV}
+						if nErr == nil && oErr == nil { //exact values to revert the change
+							change["newJSON"] = json.RawMessage(nJ)
+							change["oldJSON"] = json.RawMessage(oJ)
+						}
+						dif = append(dif, change)
 					}
 				}
 			}
@@ -469,6 +580,60 @@ func copy(dst interface{}, src interface{}) ([]map[string]interface{}, error) {
 	return dif, nil
 }
 
+//historyValue format a value for the history
+func historyValue(r reflect.Value) string {
+	switch r.Kind() {
+	case reflect.Struct:
+		if t, ok := r.Interface().(time.Time); ok {
+			return t.Format(time.RFC3339Nano)
+		}
+		if strings.HasPrefix(r.Type().String(), "null.") {
+			ret := r.MethodByName("ValueOrZero").Call([]reflect.Value{})
+			tmp := ""
+			if len(ret) > 0 && ret[0].IsValid() {
+				tmp = fmt.Sprintf("%v", ret[0].Interface())
+				switch tmp {
+				case "false":
+					tmp = "non"
+				case "true":
+					tmp = "oui"
+				}
+			}
+			return tmp
+		} else {
+			return "Type inconnu : " + r.Type().String()
+		}
+	case reflect.Array:
+		fallthrough
+	case reflect.Slice:
+		ret := []string{}
+		for i := 0; i < r.Len(); i++ {
+			name := r.Index(i).FieldByName("Name")
+			if name.IsValid() {
+				ret = append(ret, fmt.Sprintf("%+v", name.Interface()))
+			} else {
+				ret = append(ret, "...")
+			}
+		}
+		return strings.Join(ret, ",")
+	case reflect.Ptr:
+		if r.Elem().IsValid() && r.Elem().Kind() != reflect.Struct {
+			return historyValue(r.Elem())
+		}
+		if r.Elem().IsValid() {
+			name := r.Elem().FieldByName("Name")
+			if name.IsValid() {
+				return fmt.Sprintf("%+v", name.Interface())
+			} else {
+				return "..."
+			}
+		}
+	default:
+		//log.Printf("Default global %s\n", r.Kind().String())
+	}
+	return fmt.Sprintf("%+v", r.Interface())
+}
+
 func isZeroOfUnderlyingType(x interface{}) bool {
 	return x == nil || reflect.DeepEqual(x, reflect.Zero(reflect.TypeOf(x)).Interface())
 }
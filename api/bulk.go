@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//MaxBulkItems maximum number of items of a bulk request
+var MaxBulkItems = 1000
+
+//CodeBulkFailed problem code of an atomic bulk request not saved because an item failed, the results are in the errors
+const CodeBulkFailed = "bulk_failed"
+
+//BulkResult result of an item of a bulk request
+type BulkResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	Code   string      `json:"code,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+//bulkItem an item ready to be written
+type bulkItem struct {
+	result  *BulkResult
+	data    Validation
+	old     Validation
+	changes []map[string]interface{}
+}
+
+//GenericBulkCreate create the objects of an array
+//?mode=partial save the valid items, by default nothing is saved if an item fail
+func GenericBulkCreate(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}) bool) {
+	partial, raws, ok := readBulk(w, r)
+	if !ok {
+		return
+	}
+	items := make([]bulkItem, len(raws))
+	for i, raw := range raws {
+		items[i].result = &BulkResult{Index: i, Status: http.StatusCreated}
+		tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+		if err := json.Unmarshal(raw, tmp); err != nil {
+			items[i].result.fail(utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
+			continue
+		}
+		setUserEmitter(r, tmp)
+		if val, ok := tmp.Validate(); !ok {
+			items[i].result.fail(utils.ValidationProblem(val))
+			continue
+		}
+		if !f(r, tmp) {
+			items[i].result.fail(utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+			continue
+		}
+		items[i].data = tmp
+	}
+	writeBulk(w, r, items, partial, AuditCreate, func(tx *gorm.DB, data Validation) error {
+		return tx.Create(data).Error
+	})
+}
+
+//GenericBulkUpdate apply the merge patch of each item of an array on the object of the same id
+//?mode=partial save the valid items, by default nothing is saved if an item fail
+func GenericBulkUpdate(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}, data2 interface{}) bool) {
+	partial, raws, ok := readBulk(w, r)
+	if !ok {
+		return
+	}
+	fields, err := keyFields(data)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Bulk update not available"))
+		return
+	}
+	items := make([]bulkItem, len(raws))
+	for i, raw := range raws {
+		items[i].result = &BulkResult{Index: i, Status: http.StatusOK}
+		var patch map[string]interface{}
+		if err := decodeJSONDocument(raw, &patch); err != nil || patch == nil {
+			items[i].result.fail(utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Invalid patch"))
+			continue
+		}
+		values, missing := patchKey(patch, fields)
+		if missing != "" {
+			items[i].result.fail(utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Missing "+missing))
+			continue
+		}
+		tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+		if err := tmp.FindFromRequest(withKeyValues(r, tmp, values)); err != nil {
+			items[i].result.fail(utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+			continue
+		}
+		doc, err := toJSONDocument(tmp)
+		if err != nil {
+			items[i].result.fail(utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Data Error"))
+			continue
+		}
+		old := cloneObject(tmp)
+		changes, p := patchObject(r, tmp, applyMergePatch(doc, patch), f)
+		if p != nil {
+			items[i].result.fail(p)
+			continue
+		}
+		items[i].data = tmp
+		items[i].old = old
+		items[i].changes = changes
+	}
+	writeBulk(w, r, items, partial, AuditUpdate, saveObject)
+}
+
+//GenericBulkDelete delete the objects of ?id=1,2,3, the values of a composite key are joined by / (?id=acme/a1,acme/a2)
+//?mode=partial delete the allowed items, by default nothing is deleted if an item fail
+func GenericBulkDelete(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}) bool) {
+	partial, ok := bulkMode(w, r)
+	if !ok {
+		return
+	}
+	ids := strings.Split(r.FormValue("id"), ",")
+	if r.FormValue("id") == "" || len(ids) > MaxBulkItems {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, fmt.Sprintf("Between 1 and %d ids expected", MaxBulkItems)))
+		return
+	}
+	items := make([]bulkItem, len(ids))
+	for i, id := range ids {
+		items[i].result = &BulkResult{Index: i, Status: http.StatusOK}
+		tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+		err := tmp.FindFromRequest(withKeyValues(r, tmp, strings.Split(strings.TrimSpace(id), "/")))
+		setUserEmitter(r, tmp)
+		if !f(r, tmp) {
+			items[i].result.fail(utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+			continue
+		}
+		if err != nil {
+			items[i].result.fail(utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+			continue
+		}
+		items[i].data = tmp
+	}
+	writeBulk(w, r, items, partial, AuditDelete, deleteObject)
+}
+
+//patchKey return the values of the key fields of a patch, or the missing key
+func patchKey(patch map[string]interface{}, fields []*schema.Field) ([]string, string) {
+	values := []string{}
+	for _, f := range fields {
+		v, ok := patch[jsonKey(f)]
+		if !ok {
+			return nil, jsonKey(f)
+		}
+		values = append(values, fmt.Sprint(v))
+	}
+	return values, ""
+}
+
+//bulkMode return if ?mode= is partial
+func bulkMode(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	switch r.FormValue("mode") {
+	case "", "atomic":
+		return false, true
+	case "partial":
+		return true, true
+	}
+	utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid mode, atomic or partial expected"))
+	return false, false
+}
+
+//readBulk read the mode and the array of the body with the codec of its Content-Type, each item is returned as json
+func readBulk(w http.ResponseWriter, r *http.Request) (bool, []json.RawMessage, bool) {
+	partial, ok := bulkMode(w, r)
+	if !ok {
+		return false, nil, false
+	}
+	list := []interface{}{}
+	if err := utils.ReadJSON(r, &list); err != nil {
+		if errors.Is(err, utils.ErrUnsupportedMediaType) {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusUnsupportedMediaType, utils.CodeUnsupportedMediaType, "No codec for the Content-Type "+r.Header.Get("Content-Type")))
+			return false, nil, false
+		}
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
+		return false, nil, false
+	}
+	if len(list) == 0 || len(list) > MaxBulkItems {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, fmt.Sprintf("Between 1 and %d items expected", MaxBulkItems)))
+		return false, nil, false
+	}
+	raws := make([]json.RawMessage, len(list))
+	for i, v := range list {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
+			return false, nil, false
+		}
+		raws[i] = raw
+	}
+	return partial, raws, true
+}
+
+//writeBulk write the valid items in a transaction and respond the results
+//in atomic mode nothing is written if an item fail, in partial mode each item is in its own savepoint
+func writeBulk(w http.ResponseWriter, r *http.Request, items []bulkItem, partial bool, action string, write func(tx *gorm.DB, data Validation) error) {
+	failed := false
+	for _, v := range items {
+		failed = failed || v.data == nil
+	}
+	if !failed || partial {
+		err := GetDB().Transaction(func(tx *gorm.DB) error {
+			for i, v := range items {
+				if v.data == nil {
+					continue
+				}
+				point := fmt.Sprintf("bulk%d", i)
+				if partial {
+					tx.SavePoint(point)
+				}
+				if err := writeWithHooks(tx, r, action, v.old, v.data, v.changes, write); err != nil {
+					failed = true
+					v.result.fail(saveError(err))
+					if !partial {
+						return err
+					}
+					tx.RollbackTo(point)
+					continue
+				}
+				v.result.Data = v.data
+			}
+			return nil
+		})
+		if err != nil {
+			failed = true
+		}
+	}
+	results := make([]*BulkResult, len(items))
+	saved := 0
+	for i, v := range items {
+		if failed && !partial && v.result.Error == "" {
+			v.result.fail(utils.StatusProblem(http.StatusFailedDependency, "not saved, another item failed"))
+			v.result.Data = nil
+		}
+		if v.result.Error == "" {
+			saved++
+		}
+		results[i] = v.result
+	}
+	message := fmt.Sprintf("%d/%d items saved", saved, len(items))
+	if failed && !partial {
+		p := utils.NewProblem(http.StatusUnprocessableEntity, CodeBulkFailed, message)
+		p.Errors = map[string]interface{}{"items": results}
+		utils.RespondError(w, r, p)
+		return
+	}
+	resp := utils.Message(!failed, message)
+	resp["data"] = results
+	if failed {
+		utils.RespondCode(w, resp, http.StatusMultiStatus)
+		return
+	}
+	utils.Respond(w, resp)
+}
+
+//fail set the status, code and error of a failed item from its problem
+func (b *BulkResult) fail(p *utils.Problem) {
+	b.Status = p.Status
+	b.Code = p.Code
+	b.Error = p.Error()
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/loupzeur/go-crud-api/utils"
+)
+
+//UpdatedAtField name of the field used for Last-Modified
+var UpdatedAtField = "UpdatedAt"
+
+//lastModified return the UpdatedAt of data
+func lastModified(data interface{}) time.Time {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Struct {
+		return time.Time{}
+	}
+	switch t := v.FieldByName(UpdatedAtField); {
+	case !t.IsValid():
+	case t.Type() == reflect.TypeOf(time.Time{}):
+		return t.Interface().(time.Time)
+	case t.Type() == reflect.TypeOf(&time.Time{}) && !t.IsNil():
+		return *t.Interface().(*time.Time)
+	}
+	return time.Time{}
+}
+
+//responseETag return a weak entity tag of a response, used by the lists and the objects with fields or include
+func responseETag(resp map[string]interface{}) string {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return ""
+	}
+	return `W/"` + utils.GetSha(b) + `"`
+}
+
+//notModified check If-None-Match, or If-Modified-Since when absent (RFC 7232)
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return matchETag(header, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
+
+//checkNotModified set ETag and Last-Modified and respond 304 if the client copy is still valid
+//lists give no modification date : a deleted row or a row leaving the filter doesn't change it, only their weak ETag does
+//the representation depend on the negotiated codec and on the user, caches must vary on them
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Add("Vary", "Accept, Authorization")
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+)
+
+//Model constraint of the models of NewCRUD : T with its methods on *T
+type Model[T any] interface {
+	*T
+	Validation
+}
+
+//CRUD routes builder of the model T, the rights functions and the hooks get T
+//api.NewCRUD[Product]() is a *CRUD[*Product], Resource and CrudRoutes use a *CRUD[Validation] of their model
+type CRUD[T Validation] struct {
+	model                 T
+	prefix                string
+	freq                  func(r *http.Request, req *gorm.DB) *gorm.DB
+	getfunc, crefunc      func(r *http.Request, item T) bool
+	delfunc               func(r *http.Request, item T) bool
+	updfunc               func(r *http.Request, old T, new T) bool
+	listRights, getRights utils.RightBits
+	creRights, updRights  utils.RightBits
+	delRights             utils.RightBits
+	enabled               map[Verb]bool
+	actions               []resourceAction
+	parent                *resourceParent
+	hooks                 *Hooks
+}
+
+type resourceAction struct {
+	name   string
+	f      func(w http.ResponseWriter, r *http.Request, data Validation)
+	rights utils.RightBits
+}
+
+//Page a page of typed objects returned by CRUD.Find
+type Page[T any] struct {
+	Data     []T
+	Total    int64 //-1 if not counted (?count=false)
+	Page     int
+	PageSize int
+}
+
+//TypedHooks the Hooks of a CRUD, with T instead of interface{}
+type TypedHooks[T Validation] struct {
+	BeforeCreate func(r *http.Request, tx *gorm.DB, item T) error
+	AfterCreate  func(r *http.Request, tx *gorm.DB, item T) error
+	BeforeUpdate func(r *http.Request, tx *gorm.DB, old T, item T) error
+	AfterUpdate  func(r *http.Request, tx *gorm.DB, item T) error
+	BeforeDelete func(r *http.Request, tx *gorm.DB, item T) error
+	AfterDelete  func(r *http.Request, tx *gorm.DB, item T) error
+	BeforeList   func(r *http.Request, req *gorm.DB) (*gorm.DB, error)
+	AfterRead    func(r *http.Request, item T) error //on each read object, alone or in a list
+}
+
+//NewCRUD return the typed builder of the routes of T
+//List, Get, Create, Update and Delete are only routed once set, the other verbs once enabled and if the verbs they need are set (ex: patch and revert need Update)
+func NewCRUD[T any, PT Model[T]]() *CRUD[PT] {
+	return newCRUD[PT](PT(new(T)))
+}
+
+func newCRUD[T Validation](model T) *CRUD[T] {
+	access := func(r *http.Request, item T) bool {
+		return DefaultRightAccess(r, item)
+	}
+	return &CRUD[T]{
+		model:   model,
+		freq:    DefaultQueryAll,
+		getfunc: access,
+		crefunc: access,
+		updfunc: func(r *http.Request, old T, new T) bool {
+			return DefaultRightEdit(r, old, new)
+		},
+		delfunc: access,
+		enabled: map[Verb]bool{},
+	}
+}
+
+//List enable the list with its request function and rights, also used by search, aggregate, export and trash
+func (c *CRUD[T]) List(freq func(r *http.Request, req *gorm.DB) *gorm.DB, rights utils.RightBits) *CRUD[T] {
+	c.freq, c.listRights, c.enabled[List] = freq, rights, true
+	return c
+}
+
+//Get enable the read with its rights function and rights, also used by history
+func (c *CRUD[T]) Get(f func(r *http.Request, item T) bool, rights utils.RightBits) *CRUD[T] {
+	c.getfunc, c.getRights, c.enabled[Get] = f, rights, true
+	return c
+}
+
+//Create enable the create with its rights function and rights, also used by import
+func (c *CRUD[T]) Create(f func(r *http.Request, item T) bool, rights utils.RightBits) *CRUD[T] {
+	c.crefunc, c.creRights, c.enabled[Create] = f, rights, true
+	return c
+}
+
+//Update enable the update with its rights function and rights, f get the stored object and the new values
+//also used by patch, revert, the associations edits and the upserts of import
+func (c *CRUD[T]) Update(f func(r *http.Request, old T, new T) bool, rights utils.RightBits) *CRUD[T] {
+	c.updfunc, c.updRights, c.enabled[Update] = f, rights, true
+	return c
+}
+
+//Delete enable the delete with its rights function and rights, also used by trash and restore
+func (c *CRUD[T]) Delete(f func(r *http.Request, item T) bool, rights utils.RightBits) *CRUD[T] {
+	c.delfunc, c.delRights, c.enabled[Delete] = f, rights, true
+	return c
+}
+
+//Enable add the routes of the verbs on top of List, Get, Create, Update and Delete, which are only enabled by their method
+func (c *CRUD[T]) Enable(verbs ...Verb) *CRUD[T] {
+	for _, v := range verbs {
+		switch v {
+		case List, Get, Create, Update, Delete:
+		default:
+			c.enabled[v] = true
+		}
+	}
+	return c
+}
+
+//Disable remove the routes of verbs
+func (c *CRUD[T]) Disable(verbs ...Verb) *CRUD[T] {
+	for _, v := range verbs {
+		delete(c.enabled, v)
+	}
+	return c
+}
+
+//Prefix set the url prefix : /api/prefix/table
+func (c *CRUD[T]) Prefix(prefix string) *CRUD[T] {
+	c.prefix = strings.Trim(prefix, "/")
+	if c.prefix != "" {
+		c.prefix += "/"
+	}
+	return c
+}
+
+//Hooks set the hooks of the routes of the builder, instead of the hooks registered for T
+func (c *CRUD[T]) Hooks(h TypedHooks[T]) *CRUD[T] {
+	hooks := Hooks{BeforeList: h.BeforeList}
+	if h.BeforeCreate != nil {
+		hooks.BeforeCreate = func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			return h.BeforeCreate(r, tx, data.(T))
+		}
+	}
+	if h.AfterCreate != nil {
+		hooks.AfterCreate = func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			return h.AfterCreate(r, tx, data.(T))
+		}
+	}
+	if h.BeforeUpdate != nil {
+		hooks.BeforeUpdate = func(r *http.Request, tx *gorm.DB, old interface{}, data interface{}) error {
+			return h.BeforeUpdate(r, tx, old.(T), data.(T))
+		}
+	}
+	if h.AfterUpdate != nil {
+		hooks.AfterUpdate = func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			return h.AfterUpdate(r, tx, data.(T))
+		}
+	}
+	if h.BeforeDelete != nil {
+		hooks.BeforeDelete = func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			return h.BeforeDelete(r, tx, data.(T))
+		}
+	}
+	if h.AfterDelete != nil {
+		hooks.AfterDelete = func(r *http.Request, tx *gorm.DB, data interface{}) error {
+			return h.AfterDelete(r, tx, data.(T))
+		}
+	}
+	if h.AfterRead != nil {
+		hooks.AfterRead = func(r *http.Request, data interface{}) error {
+			return h.AfterRead(r, data.(T))
+		}
+	}
+	c.hooks = &hooks
+	return c
+}
+
+//withHooks return r with the hooks of the builder, if set
+func (c *CRUD[T]) withHooks(r *http.Request) *http.Request {
+	if c.hooks == nil {
+		return r
+	}
+	return withHooks(r, c.model, *c.hooks)
+}
+
+//Action add a POST /api/table/{key}/name route calling f with the object, loaded with FindFromRequest
+func (c *CRUD[T]) Action(name string, f func(w http.ResponseWriter, r *http.Request, item T), rights utils.RightBits) *CRUD[T] {
+	c.actions = append(c.actions, resourceAction{name: name, f: func(w http.ResponseWriter, r *http.Request, data Validation) {
+		f(w, r, data.(T))
+	}, rights: rights})
+	return c
+}
+
+//Routes return the routes of the enabled verbs and of the actions, the objects are found by the keys of Keyed models else by {id}
+func (c *CRUD[T]) Routes() utils.Routes {
+	var models Validation = c.model
+	getfunc := func(r *http.Request, data interface{}) bool {
+		return c.getfunc(r, data.(T))
+	}
+	crefunc := func(r *http.Request, data interface{}) bool {
+		return c.crefunc(r, data.(T))
+	}
+	updfunc := func(r *http.Request, data interface{}, data2 interface{}) bool {
+		return c.updfunc(r, data.(T), data2.(T))
+	}
+	delfunc := func(r *http.Request, data interface{}) bool {
+		return c.delfunc(r, data.(T))
+	}
+	parentName := strings.Split(c.prefix, "/")
+	if c.parent != nil {
+		parentName[0] += c.parent.model.TableName()
+	}
+	name := func(action string) string {
+		return action + parentName[0] + strings.Title(models.TableName())
+	}
+	pattern := "/api/" + c.prefix + c.parent.pattern() + models.TableName()
+	routes := utils.Routes{}
+	negotiated := func(negotiate func(h http.HandlerFunc) http.HandlerFunc) func(route utils.Route, verbs ...Verb) {
+		return func(route utils.Route, verbs ...Verb) {
+			for _, v := range verbs {
+				if !c.enabled[v] {
+					return
+				}
+			}
+			h := route.HandlerFunc
+			route.HandlerFunc = negotiate(func(w http.ResponseWriter, r *http.Request) {
+				h(w, c.withHooks(r))
+			})
+			routes = append(routes, route)
+		}
+	}
+	add := negotiated(utils.Negotiate)
+	addRaw := negotiated(utils.NegotiateResponse) //the handler read its own body formats
+
+	key := keyPattern(models)
+	scoped := func(h http.HandlerFunc) http.HandlerFunc {
+		if c.parent == nil {
+			return h
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r, ok := c.parent.scope(w, r, models); ok {
+				h(w, r)
+			}
+		}
+	}
+	keyed := func(h http.HandlerFunc) http.HandlerFunc {
+		return scoped(func(w http.ResponseWriter, r *http.Request) {
+			h(w, withKey(r, models))
+		})
+	}
+
+	export := scoped(func(w http.ResponseWriter, r *http.Request) {
+		GenericExport(w, r, models, c.freq)
+	})
+	//exports answer their own media types, the Accept header is checked before the negotiation of the codecs
+	addExported := func(route utils.Route, verbs ...Verb) {
+		n := len(routes)
+		add(route, verbs...)
+		if len(routes) > n && c.enabled[Export] {
+			list := routes[n].HandlerFunc
+			routes[n].HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+				if acceptedExport(r.Header.Get("Accept")) != "" {
+					export(w, r)
+					return
+				}
+				list(w, r)
+			}
+		}
+	}
+
+	//static routes first, before a key matching them (ex: a slug "trash")
+	addExported(utils.Route{Name: name("GetAll"), Method: "GET", Pattern: pattern,
+		HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+			GenericGetQueryAll(w, r, models, c.freq)
+		}), Authorization: uint32(c.listRights)}, List)
+	add(utils.Route{Name: name("Search"), Method: "POST", Pattern: pattern + "/search",
+		HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+			GenericSearch(w, r, models, c.freq)
+		}), Authorization: uint32(c.listRights)}, List, Search)
+	if _, ok := models.(Aggregatable); ok {
+		add(utils.Route{Name: name("Aggregate"), Method: "GET", Pattern: pattern + "/aggregate",
+			HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+				GenericAggregate(w, r, models, c.freq)
+			}), Authorization: uint32(c.listRights)}, List, Aggregate)
+	}
+	addExported(utils.Route{Name: name("Export"), Method: "GET", Pattern: pattern + "/export",
+		HandlerFunc: export, Authorization: uint32(c.listRights)}, List, Export)
+	softDelete, isSoftDeletable := models.(SoftDeletable)
+	if isSoftDeletable {
+		add(utils.Route{Name: name("Trash"), Method: "GET", Pattern: pattern + "/trash",
+			HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+				GenericTrash(w, r, models, c.freq)
+			}), Authorization: uint32(softDelete.SoftDeleteRights().Trash)}, Delete, Trash)
+	}
+	//the upserts of the imports are updates, they need the update route and rights
+	upsertfunc := func(r *http.Request, data interface{}, data2 interface{}) bool {
+		return c.enabled[Update] && (c.updRights == utils.NoRight || utils.HasRightsRequest(r, c.updRights)) && updfunc(r, data, data2)
+	}
+	add(utils.Route{Name: name("Create"), Method: "POST", Pattern: pattern,
+		HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+			GenericCreate(w, r, models, crefunc)
+		}), Authorization: uint32(c.creRights)}, Create)
+	addRaw(utils.Route{Name: name("Import"), Method: "POST", Pattern: pattern + "/import",
+		HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+			GenericImport(w, r, models, crefunc, upsertfunc)
+		}), Authorization: uint32(c.creRights)}, Create, Import)
+	add(utils.Route{Name: name("BulkCreate"), Method: "POST", Pattern: pattern + "/bulk",
+		HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+			GenericBulkCreate(w, r, models, crefunc)
+		}), Authorization: uint32(c.creRights)}, Bulk, Create)
+	add(utils.Route{Name: name("BulkUpdate"), Method: "PATCH", Pattern: pattern + "/bulk",
+		HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+			GenericBulkUpdate(w, r, models, updfunc)
+		}), Authorization: uint32(c.updRights)}, Bulk, Update)
+	add(utils.Route{Name: name("BulkDelete"), Method: "DELETE", Pattern: pattern,
+		HandlerFunc: scoped(func(w http.ResponseWriter, r *http.Request) {
+			GenericBulkDelete(w, r, models, delfunc)
+		}), Authorization: uint32(c.delRights)}, Bulk, Delete)
+
+	add(utils.Route{Name: name("Get"), Method: "GET", Pattern: pattern + key,
+		HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+			GenericGet(w, r, models, getfunc)
+		}), Authorization: uint32(c.getRights)}, Get)
+	add(utils.Route{Name: name("Update"), Method: "PUT", Pattern: pattern + key,
+		HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+			GenericUpdate(w, r, models, updfunc)
+		}), Authorization: uint32(c.updRights)}, Update)
+	add(utils.Route{Name: name("Patch"), Method: "PATCH", Pattern: pattern + key,
+		HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+			GenericPatch(w, r, models, updfunc)
+		}), Authorization: uint32(c.updRights)}, Update, Patch)
+	add(utils.Route{Name: name("Delete"), Method: "DELETE", Pattern: pattern + key,
+		HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+			GenericDelete(w, r, models, delfunc)
+		}), Authorization: uint32(c.delRights)}, Delete)
+	if _, ok := models.(HistoryAble); ok {
+		add(utils.Route{Name: name("History"), Method: "GET", Pattern: pattern + key + "/history",
+			HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+				GenericHistory(w, r, models, getfunc)
+			}), Authorization: uint32(c.getRights)}, Get, History)
+		add(utils.Route{Name: name("Revert"), Method: "POST", Pattern: pattern + key + "/revert",
+			HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+				GenericRevert(w, r, models, updfunc)
+			}), Authorization: uint32(c.updRights)}, Update, Revert)
+	}
+	if isSoftDeletable {
+		add(utils.Route{Name: name("Restore"), Method: "POST", Pattern: pattern + key + "/restore",
+			HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+				GenericRestore(w, r, models, delfunc)
+			}), Authorization: uint32(softDelete.SoftDeleteRights().Restore)}, Delete, Restore)
+	}
+	if v, ok := models.(Associable); ok {
+		associations := v.Associations()
+		assocNames := make([]string, 0, len(associations))
+		for k := range associations {
+			assocNames = append(assocNames, k)
+		}
+		sort.Strings(assocNames)
+		for _, v := range assocNames {
+			assoc, rights := v, associations[v]
+			assocPattern := pattern + key + "/" + strings.ToLower(assoc)
+			add(utils.Route{Name: name("List" + strings.Title(assoc)), Method: "GET", Pattern: assocPattern,
+				HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+					GenericAssociationList(w, r, models, assoc, getfunc)
+				}), Authorization: uint32(rights.Read)}, Get, Associations)
+			for _, method := range []string{"POST", "PUT", "DELETE"} {
+				add(utils.Route{Name: name(strings.Title(strings.ToLower(method)) + strings.Title(assoc)), Method: method, Pattern: assocPattern,
+					HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+						GenericAssociationEdit(w, r, models, assoc, updfunc)
+					}), Authorization: uint32(rights.Write)}, Update, Associations)
+			}
+		}
+	}
+	for _, v := range c.actions {
+		action := v
+		add(utils.Route{Name: name(strings.Title(action.name)), Method: "POST", Pattern: pattern + key + "/" + action.name,
+			HandlerFunc: keyed(func(w http.ResponseWriter, r *http.Request) {
+				GenericAction(w, r, models, action.f)
+			}), Authorization: uint32(action.rights)})
+	}
+	return routes
+}
+
+//newItem return a new empty T
+func (c *CRUD[T]) newItem() T {
+	return reflect.New(reflect.TypeOf(c.model).Elem()).Interface().(T)
+}
+
+//Find return the page of T of the list request r, with the same parameters as the list route
+func (c *CRUD[T]) Find(r *http.Request) (*Page[T], error) {
+	r = c.withHooks(r)
+	offset, pagesize, _ := GetAllFromDb(r)
+	if pagesize <= 0 {
+		return nil, errors.New("invalid page or pagesize")
+	}
+	filter, err := filterParam(r)
+	if err != nil {
+		return nil, err
+	}
+	q, err := prepareList(r, c.model, c.freq, filter)
+	if err != nil {
+		return nil, err
+	}
+	req := applySort(q.req, q.sortKeys)
+	page := &Page[T]{Data: []T{}, Total: -1, Page: offset/pagesize + 1, PageSize: pagesize}
+	if r.FormValue("count") != "false" {
+		if page.Total, _, err = DefaultCountFunc(r, req); err != nil {
+			return nil, err
+		}
+	}
+	items := reflect.New(reflect.SliceOf(reflect.TypeOf(c.model)))
+	if err := req.Offset(offset).Limit(pagesize).Find(items.Interface()).Error; err != nil {
+		return nil, err
+	}
+	for i := 0; i < items.Elem().Len(); i++ {
+		page.Data = append(page.Data, items.Elem().Index(i).Interface().(T))
+	}
+	return page, afterRead(r, c.model, items.Interface())
+}
+
+//First return the T of the key ({id}) of r, like the read route without the rights function
+func (c *CRUD[T]) First(r *http.Request) (T, error) {
+	r = c.withHooks(r)
+	item := c.newItem()
+	if err := item.FindFromRequest(withKey(r, c.model)); err != nil {
+		var zero T
+		return zero, err
+	}
+	return item, afterRead(r, c.model, item)
+}
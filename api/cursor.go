@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+//keysetCursor position of a row in a keyset pagination : values of the sort keys and primary key
+type keysetCursor struct {
+	Values   []json.RawMessage `json:"v"`
+	Previous bool              `json:"p,omitempty"`
+}
+
+//encodeCursor return an opaque cursor on the row
+func encodeCursor(fields []*schema.Field, row reflect.Value, previous bool) string {
+	c := keysetCursor{Previous: previous}
+	for _, f := range fields {
+		v, _ := f.ValueOf(reflect.Indirect(row))
+		b, _ := json.Marshal(v)
+		c.Values = append(c.Values, b)
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//decodeCursor return the values typed as fields from an opaque cursor
+func decodeCursor(cursor string, fields []*schema.Field) ([]interface{}, bool, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, false, errors.New("invalid cursor")
+	}
+	c := keysetCursor{}
+	if err := json.Unmarshal(b, &c); err != nil || len(c.Values) != len(fields) {
+		return nil, false, errors.New("invalid cursor")
+	}
+	values := []interface{}{}
+	for i, f := range fields {
+		v := reflect.New(f.FieldType)
+		if err := json.Unmarshal(c.Values[i], v.Interface()); err != nil {
+			return nil, false, errors.New("invalid cursor")
+		}
+		values = append(values, v.Elem().Interface())
+	}
+	return values, c.Previous, nil
+}
+
+//keysetCondition return (k1 > v1) OR (k1 = v1 AND k2 > v2) ... with < on descending keys
+func keysetCondition(db *gorm.DB, keys []SortKey, values []interface{}) clause.Expression {
+	or := []string{}
+	vars := []interface{}{}
+	for i, k := range keys {
+		and := []string{}
+		for j := 0; j < i; j++ {
+			and = append(and, keys[j].Expression(db, keys[j].Column)+" = "+keys[j].Expression(db, "?"))
+			vars = append(vars, values[j])
+		}
+		op := " > "
+		if k.Desc {
+			op = " < "
+		}
+		and = append(and, k.Expression(db, k.Column)+op+k.Expression(db, "?"))
+		vars = append(vars, values[i])
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+	return clause.Expr{SQL: "(" + strings.Join(or, " OR ") + ")", Vars: vars}
+}
+
+//genericGetKeyset return elements after (or before) the cursor sorted by keys then by primary key
+//the total count is only done with count=true
+func genericGetKeyset(w http.ResponseWriter, r *http.Request, data Validation, req *gorm.DB, keys []SortKey, selection *FieldSelection) {
+	sch, err := parseSchema(data)
+	if err != nil || sch.PrioritizedPrimaryField == nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Cursor pagination not available"))
+		return
+	}
+	pk := sch.PrioritizedPrimaryField
+	fields := []*schema.Field{}
+	hasPk := false
+	for _, k := range keys {
+		f := sch.LookUpField(strings.TrimPrefix(k.Column, sch.Table+"."))
+		if f == nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Cursor pagination not available on "+k.Column))
+			return
+		}
+		hasPk = hasPk || f == pk
+		fields = append(fields, f)
+	}
+	if !hasPk { //primary key as tie-breaker
+		fields = append(fields, pk)
+		keys = append(keys, SortKey{Column: sch.Table + "." + pk.DBName, Desc: len(keys) > 0 && keys[0].Desc})
+	}
+
+	limit, err := utils.ReadInt(r, "limit", 20)
+	if err != nil || limit <= 0 {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid limit"))
+		return
+	}
+	cursor := r.FormValue("cursor")
+	backward := false
+	values := []interface{}{}
+	if cursor != "" {
+		if values, backward, err = decodeCursor(cursor, fields); err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, err.Error()))
+			return
+		}
+	}
+
+	resp := utils.Message(true, "data returned")
+	if r.FormValue("count") == "true" {
+		count := int64(0)
+		if count, resp, err = DefaultCountFunc(r, req); err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+			return
+		}
+		resp["total_nb_values"] = count
+	}
+
+	//going backward : reverse the order then the results
+	if backward {
+		reversed := []SortKey{}
+		for _, k := range keys {
+			k.Desc = !k.Desc
+			reversed = append(reversed, k)
+		}
+		keys = reversed
+	}
+	if cursor != "" {
+		req = req.Where(keysetCondition(req, keys, values))
+	}
+	req = applySort(req, keys)
+	pages := reflect.New(reflect.SliceOf(reflect.TypeOf(data)))
+	if err := req.Limit(int(limit) + 1).Find(pages.Interface()).Error; err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+		return
+	}
+	rows := pages.Elem()
+	hasMore := rows.Len() > int(limit)
+	if hasMore {
+		rows = rows.Slice(0, int(limit))
+	}
+	if backward {
+		for i, j := 0, rows.Len()-1; i < j; i, j = i+1, j-1 {
+			tmp := rows.Index(i).Interface()
+			rows.Index(i).Set(rows.Index(j))
+			rows.Index(j).Set(reflect.ValueOf(tmp))
+		}
+	}
+
+	resp["next_cursor"] = nil
+	resp["prev_cursor"] = nil
+	if rows.Len() > 0 {
+		if hasMore || backward {
+			resp["next_cursor"] = encodeCursor(fields, rows.Index(rows.Len()-1), false)
+		}
+		if (hasMore && backward) || (!backward && cursor != "") {
+			resp["prev_cursor"] = encodeCursor(fields, rows.Index(0), true)
+		}
+	}
+	if err := afterRead(r, data, rows.Interface()); err != nil {
+		respondHookError(w, r, err)
+		return
+	}
+	resp["data"] = selection.Filter(rows.Interface())
+	resp["size_page"] = limit
+	if checkNotModified(w, r, responseETag(resp), time.Time{}) {
+		return
+	}
+	utils.Respond(w, resp)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//ErrVersionConflict returned when a Versioned object was modified since it was loaded
+var ErrVersionConflict = errors.New("object modified since loaded")
+
+//ETag return the entity tag of data : its version when Versioned else a sha of its columns
+func ETag(data Validation) string {
+	sch, err := parseSchema(data)
+	if err != nil {
+		return ""
+	}
+	value := reflect.Indirect(reflect.ValueOf(data))
+	if field := versionField(data, sch); field != nil {
+		v, _ := field.ValueOf(value)
+		return fmt.Sprintf(`"v%v"`, v)
+	}
+	columns := map[string]interface{}{}
+	for _, f := range sch.Fields {
+		if f.DBName != "" {
+			columns[f.DBName], _ = f.ValueOf(value)
+		}
+	}
+	b, err := json.Marshal(columns)
+	if err != nil {
+		return ""
+	}
+	return `"` + utils.GetSha(b) + `"`
+}
+
+//versionField return the version field of a Versioned data
+func versionField(data interface{}, sch *schema.Schema) *schema.Field {
+	v, ok := data.(Versioned)
+	if !ok {
+		return nil
+	}
+	return sch.LookUpField(v.VersionColumn())
+}
+
+//ifMatch check the If-Match header of the request against the entity tag of data
+func ifMatch(r *http.Request, data Validation) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	return matchETag(header, ETag(data))
+}
+
+//matchETag check if etag is in the list of header, weak tags are compared as strong
+func matchETag(header string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || (etag != "" && v == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+//saveObject save data, a Versioned data is only updated if its version didn't change and get the next one
+func saveObject(tx *gorm.DB, data Validation) error {
+	sch, err := parseSchema(data)
+	if err != nil {
+		return err
+	}
+	field := versionField(data, sch)
+	if field == nil {
+		return tx.Save(data).Error
+	}
+	value := field.ReflectValueOf(reflect.Indirect(reflect.ValueOf(data)))
+	current := value.Interface()
+	if isNewObject(sch, data) {
+		return tx.Save(data).Error
+	}
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(value.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(value.Uint() + 1)
+	default:
+		return fmt.Errorf("version column %s must be an integer", field.DBName)
+	}
+	res := tx.Select("*").Where(field.DBName+" = ?", current).Save(data)
+	if res.Error == nil && res.RowsAffected == 0 {
+		value.Set(reflect.ValueOf(current))
+		return ErrVersionConflict
+	}
+	return res.Error
+}
+
+//deleteObject delete data, a Versioned data is only deleted if its version didn't change
+func deleteObject(tx *gorm.DB, data Validation) error {
+	sch, err := parseSchema(data)
+	if err != nil {
+		return err
+	}
+	var target interface{} = data
+	if len(sch.PrimaryFields) > 1 { //a condition by column, (a, b) IN ((?, ?)) is not supported by sqlite
+		tx = tx.Where(primaryConditions(sch, data))
+		target = reflect.New(sch.ModelType).Interface()
+	}
+	field := versionField(data, sch)
+	if field == nil {
+		return tx.Delete(target).Error
+	}
+	current, _ := field.ValueOf(reflect.Indirect(reflect.ValueOf(data)))
+	res := tx.Where(field.DBName+" = ?", current).Delete(target)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return res.Error
+}
+
+//respondSaveError respond the problem of a hook error, 412 on version conflict else 500
+func respondSaveError(w http.ResponseWriter, r *http.Request, err error) {
+	utils.RespondError(w, r, saveError(err))
+}
+
+//saveError return the problem of an error while saving
+func saveError(err error) *utils.Problem {
+	if p, ok := hookProblem(err); ok {
+		return p
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		return utils.NewProblem(http.StatusPreconditionFailed, utils.CodePreconditionFailed, err.Error())
+	}
+	if isDuplicateKey(err) {
+		return utils.NewProblem(http.StatusConflict, utils.CodeConflict, "Duplicate key")
+	}
+	return utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error saving")
+}
+
+//isDuplicateKey return if err is the unique constraint violation of sqlite, mysql, postgres or sqlserver
+func isDuplicateKey(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, v := range []string{"unique constraint failed", "duplicate entry", "duplicate key"} {
+		if strings.Contains(msg, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//Export formats of ?format= and their media types
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+)
+
+var exportMediaTypes = map[string]string{
+	ExportCSV:  "text/csv",
+	ExportXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+//ExportFlushRows number of rows written between two flushes of the export
+var ExportFlushRows = 100
+
+//ExportColumn a column of the exports : its header, the struct field (or db column) and an optional formatter
+//the texts starting with = + - @ tab or carriage return are prefixed with ' to not be run as formulas, unless Raw
+type ExportColumn struct {
+	Header string
+	Field  string
+	Format func(v interface{}) string
+	Raw    bool
+}
+
+//GenericExport stream the list as csv or xlsx (?format= or Accept), with the filters, sort and scoping of GenericGetQueryAll
+//the rows are read with a cursor instead of loading the whole list
+func GenericExport(w http.ResponseWriter, r *http.Request, data Validation, freq func(r *http.Request, req *gorm.DB) *gorm.DB) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = acceptedExport(r.Header.Get("Accept"))
+	}
+	if _, ok := exportMediaTypes[format]; !ok {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Export format csv or xlsx expected"))
+		return
+	}
+	filter, err := filterParam(r)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidFilter, err.Error()))
+		return
+	}
+	columns, fields, err := exportColumns(data)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, err.Error()))
+		return
+	}
+	sortKeys, err := GetSort(r, data)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidFilter, err.Error()))
+		return
+	}
+	req, err := filteredList(r, data, data.QueryAllFromRequest(r, GetDB()).Model(data), freq, filter)
+	if err != nil {
+		respondListError(w, r, err)
+		return
+	}
+	rows, err := applySort(req, sortKeys).Rows()
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", exportMediaTypes[format])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": data.TableName() + "." + format}))
+	var out exportWriter
+	if format == ExportXLSX {
+		out, err = newXLSXExport(w, data.TableName())
+	} else {
+		out = newCSVExport(w)
+	}
+	headers := make([]interface{}, len(columns))
+	for i, c := range columns {
+		headers[i] = escapeFormula(c.Header)
+	}
+	if err == nil {
+		err = out.Write(headers)
+	}
+	flusher, _ := w.(http.Flusher)
+	for n := 1; err == nil && rows.Next(); n++ {
+		tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface()
+		if err = GetDB().ScanRows(rows, tmp); err != nil {
+			break
+		}
+		if err = afterRead(r, data, tmp); err != nil {
+			break
+		}
+		cells := make([]interface{}, len(columns))
+		for i, c := range columns {
+			v, _ := fields[i].ValueOf(reflect.ValueOf(tmp).Elem())
+			if c.Format != nil {
+				cells[i] = c.Format(v)
+			} else {
+				cells[i] = exportValue(v)
+			}
+			if s, ok := cells[i].(string); ok && !c.Raw {
+				cells[i] = escapeFormula(s)
+			}
+		}
+		err = out.Write(cells)
+		if flusher != nil && n%ExportFlushRows == 0 {
+			out.Flush()
+			flusher.Flush()
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil { //the status is already sent
+		log.Println("export of", data.TableName(), "interrupted :", err.Error())
+	}
+}
+
+//acceptedExport return the export format of the preferred type of an Accept header
+func acceptedExport(accept string) string {
+	type accepted struct {
+		mediaType string
+		q         float64
+	}
+	list := []accepted{}
+	for _, v := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		q, err := strconv.ParseFloat(params["q"], 64)
+		if err != nil {
+			q = 1
+		}
+		list = append(list, accepted{mediaType, q})
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+	if len(list) > 0 {
+		for format, mediaType := range exportMediaTypes {
+			if list[0].mediaType == mediaType {
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+//exportColumns return the ExportColumns of data, by default its db columns without the json:"-" fields, with their schema fields
+func exportColumns(data Validation) ([]ExportColumn, []*schema.Field, error) {
+	sch, err := parseSchema(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := []ExportColumn{}
+	if v, ok := data.(Exportable); ok {
+		columns = v.ExportColumns()
+	} else {
+		for _, f := range sch.Fields {
+			if f.DBName != "" && jsonKey(f) != "-" {
+				columns = append(columns, ExportColumn{Header: f.DBName, Field: f.Name})
+			}
+		}
+	}
+	fields := make([]*schema.Field, len(columns))
+	for i, c := range columns {
+		if fields[i] = sch.LookUpField(c.Field); fields[i] == nil {
+			return nil, nil, fmt.Errorf("unknown export field %q", c.Field)
+		}
+	}
+	return columns, fields, nil
+}
+
+//exportValue return the default value of a cell : numbers are kept, times are formatted, nil pointers are empty
+func exportValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return ""
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return ""
+	}
+	switch t := rv.Interface().(type) {
+	case time.Time:
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04:05")
+	case gorm.DeletedAt:
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Format("2006-01-02 15:04:05")
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return rv.Interface()
+	}
+	return fmt.Sprint(rv.Interface())
+}
+
+//escapeFormula prefix with ' a text read as a formula by the spreadsheets
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+//exportWriter write the rows of an export
+type exportWriter interface {
+	Write(cells []interface{}) error
+	Flush()
+	Close() error
+}
+
+//csvExport write the rows as csv
+type csvExport struct {
+	w *csv.Writer
+}
+
+func newCSVExport(w io.Writer) *csvExport {
+	return &csvExport{w: csv.NewWriter(w)}
+}
+
+func (e *csvExport) Write(cells []interface{}) error {
+	record := make([]string, len(cells))
+	for i, v := range cells {
+		record[i] = fmt.Sprint(v)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExport) Flush() {
+	e.w.Flush()
+}
+
+func (e *csvExport) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+//xlsxExport write the rows in the only sheet of a xlsx workbook, streamed in a zip
+type xlsxExport struct {
+	zip   *zip.Writer
+	sheet io.Writer
+}
+
+//xlsx parts written before the sheet
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+}
+
+func newXLSXExport(w io.Writer, name string) (*xlsxExport, error) {
+	e := &xlsxExport{zip: zip.NewWriter(w)}
+	for _, part := range xlsxParts {
+		content := part.content
+		if strings.Contains(content, "%s") {
+			content = fmt.Sprintf(content, xmlEscape(sheetName(name)))
+		}
+		if err := e.writePart(part.name, content); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := e.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	e.sheet = sheet
+	_, err = io.WriteString(sheet, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return e, err
+}
+
+//sheetName return name without the characters forbidden in a sheet name ([ ] : * ? / \), limited to 31 characters
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if name = strings.Trim(name, "'"); name == "" { //nor start or end with '
+		return "Sheet1"
+	}
+	return name
+}
+
+func (e *xlsxExport) writePart(name string, content string) error {
+	f, err := e.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, xml.Header+content)
+	return err
+}
+
+func (e *xlsxExport) Write(cells []interface{}) error {
+	var b strings.Builder
+	b.WriteString("<row>")
+	for _, v := range cells {
+		switch v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			b.WriteString(`<c><v>` + fmt.Sprint(v) + `</v></c>`)
+		default:
+			b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">` + xmlEscape(fmt.Sprint(v)) + `</t></is></c>`)
+		}
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(e.sheet, b.String())
+	return err
+}
+
+func (e *xlsxExport) Flush() {
+	e.zip.Flush()
+}
+
+func (e *xlsxExport) Close() error {
+	if _, err := io.WriteString(e.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//FieldSelection columns, associations and json keys asked with ?fields=
+type FieldSelection struct {
+	schema   *schema.Schema
+	columns  []string
+	keys     map[string]*FieldSelection //json key to keep, nil to keep the whole value
+	preloads map[string]*FieldSelection //association to preload, nil to load it whole
+}
+
+//GetFieldSelection return the selection of ?fields=id,name,owner.name checked against SelectableFields
+//nil if no fields are asked
+func GetFieldSelection(r *http.Request, data Validation) (*FieldSelection, error) {
+	fields := r.FormValue("fields")
+	if fields == "" {
+		return nil, nil
+	}
+	selectable, ok := data.(FieldSelectable)
+	if !ok {
+		return nil, fmt.Errorf("fields selection not available on %s", data.TableName())
+	}
+	allowed := map[string]bool{}
+	for _, v := range selectable.SelectableFields() {
+		allowed[strings.ToLower(v)] = true
+	}
+	sch, err := parseSchema(data)
+	if err != nil {
+		return nil, err
+	}
+	s := newFieldSelection(sch)
+	for _, v := range strings.Split(fields, ",") {
+		path := strings.Split(strings.ToLower(strings.TrimSpace(v)), ".")
+		ok := false
+		for i := range path { //owner allow owner.name
+			ok = ok || allowed[strings.Join(path[:i+1], ".")]
+		}
+		if !ok {
+			return nil, fmt.Errorf("field %q not selectable", v)
+		}
+		if err := s.add(path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func newFieldSelection(sch *schema.Schema) *FieldSelection {
+	s := &FieldSelection{schema: sch, keys: map[string]*FieldSelection{}, preloads: map[string]*FieldSelection{}}
+	for _, f := range sch.PrimaryFields {
+		s.addColumn(f.DBName)
+	}
+	return s
+}
+
+func (s *FieldSelection) addColumn(column string) {
+	for _, v := range s.columns {
+		if v == column {
+			return
+		}
+	}
+	s.columns = append(s.columns, column)
+}
+
+//addLinks add the columns of this side linking the relation, they must be loaded to preload it
+func (s *FieldSelection) addLinks(rel *schema.Relationship) {
+	for _, ref := range rel.References {
+		for _, f := range []*schema.Field{ref.PrimaryKey, ref.ForeignKey} {
+			if f != nil && f.Schema == s.schema {
+				s.addColumn(f.DBName)
+			}
+		}
+	}
+}
+
+func (s *FieldSelection) add(path []string) error {
+	name := path[0]
+	for _, rel := range s.schema.Relationships.Relations {
+		key := jsonKey(rel.Field)
+		if strings.ToLower(rel.Name) != name && strings.ToLower(key) != name {
+			continue
+		}
+		s.addLinks(rel)
+		sub, exists := s.preloads[rel.Name]
+		if len(path) == 1 || (exists && sub == nil) {
+			s.preloads[rel.Name] = nil
+			s.keys[key] = nil
+			return nil
+		}
+		if !exists {
+			sub = newFieldSelection(rel.FieldSchema)
+			sub.addLinks(rel)
+			s.preloads[rel.Name] = sub
+			s.keys[key] = sub
+		}
+		return sub.add(path[1:])
+	}
+	for _, f := range s.schema.Fields {
+		key := jsonKey(f)
+		if f.DBName == "" || key == "-" || (f.DBName != name && strings.ToLower(key) != name) {
+			continue
+		}
+		if len(path) > 1 {
+			return fmt.Errorf("field %q has no sub field", name)
+		}
+		s.addColumn(f.DBName)
+		s.keys[key] = nil
+		return nil
+	}
+	return fmt.Errorf("unknown field %q", name)
+}
+
+//Scope restrict the query to the selected columns and associations
+func (s *FieldSelection) Scope(db *gorm.DB) *gorm.DB {
+	if s == nil {
+		return db
+	}
+	db = db.Select(s.columns)
+	for name, sub := range s.preloads {
+		if sub == nil {
+			db = db.Preload(name)
+		} else {
+			db = db.Preload(name, sub.Scope)
+		}
+	}
+	return db
+}
+
+//Filter return data with only the selected keys
+func (s *FieldSelection) Filter(data interface{}) interface{} {
+	if s == nil {
+		return data
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return data
+	}
+	return s.filter(v)
+}
+
+func (s *FieldSelection) filter(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []interface{}:
+		for i := range t {
+			t[i] = s.filter(t[i])
+		}
+		return t
+	case map[string]interface{}:
+		ret := map[string]interface{}{}
+		for k, sub := range s.keys {
+			if val, ok := t[k]; ok {
+				if sub != nil {
+					val = sub.filter(val)
+				}
+				ret[k] = val
+			}
+		}
+		return ret
+	}
+	return v
+}
+
+//parseSchema return the gorm schema of data
+func parseSchema(data interface{}) (*schema.Schema, error) {
+	if GetDB() == nil { //routes can be built before SetDB
+		return nil, errors.New("no database set")
+	}
+	stmt := &gorm.Statement{DB: GetDB()}
+	if err := stmt.Parse(data); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+//jsonKey return the key of the field once serialized
+func jsonKey(f *schema.Field) string {
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+	return f.Name
+}
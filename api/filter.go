@@ -0,0 +1,135 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//FilterError returned when a filter from the url can't be applied
+type FilterError struct {
+	Column   string
+	Operator string
+	Reason   string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter %s[%s] : %s", e.Column, e.Operator, e.Reason)
+}
+
+//FilterOperators available in url filters as column[operator]=value
+//each one return a parametrized where clause, column come from FilterColumns so no injection here
+var FilterOperators = map[string]func(column string, values []string) (string, []interface{}, error){
+	"eq": func(column string, values []string) (string, []interface{}, error) {
+		return column + " = ?", []interface{}{values[0]}, nil
+	},
+	"ne": func(column string, values []string) (string, []interface{}, error) {
+		return column + " <> ?", []interface{}{values[0]}, nil
+	},
+	"gt": func(column string, values []string) (string, []interface{}, error) {
+		return column + " > ?", []interface{}{values[0]}, nil
+	},
+	"gte": func(column string, values []string) (string, []interface{}, error) {
+		return column + " >= ?", []interface{}{values[0]}, nil
+	},
+	"lt": func(column string, values []string) (string, []interface{}, error) {
+		return column + " < ?", []interface{}{values[0]}, nil
+	},
+	"lte": func(column string, values []string) (string, []interface{}, error) {
+		return column + " <= ?", []interface{}{values[0]}, nil
+	},
+	"like": func(column string, values []string) (string, []interface{}, error) {
+		return column + " LIKE ?", []interface{}{"%" + values[0] + "%"}, nil
+	},
+	"ilike": func(column string, values []string) (string, []interface{}, error) {
+		//LOWER is available on every dialect where ILIKE is not
+		return "LOWER(" + column + ") LIKE LOWER(?)", []interface{}{"%" + values[0] + "%"}, nil
+	},
+	"in": func(column string, values []string) (string, []interface{}, error) {
+		return column + " IN (?)", []interface{}{splitFilterValues(values)}, nil
+	},
+	"nin": func(column string, values []string) (string, []interface{}, error) {
+		return column + " NOT IN (?)", []interface{}{splitFilterValues(values)}, nil
+	},
+	"null": func(column string, values []string) (string, []interface{}, error) {
+		switch values[0] {
+		case "true", "1":
+			return column + " IS NULL", nil, nil
+		case "false", "0":
+			return column + " IS NOT NULL", nil, nil
+		}
+		return "", nil, fmt.Errorf("expected true or false, got %q", values[0])
+	},
+}
+
+//applyFilterOperator add the where clause of column[operator] if allowed by the column declaration
+func applyFilterOperator(req *gorm.DB, column string, operator string, declaration string, values []string) *gorm.DB {
+	expr, err := compileFilterOperator(column, operator, declaration, values)
+	if err != nil {
+		req.AddError(err)
+		return req
+	}
+	return req.Where(expr)
+}
+
+//compileFilterOperator return the where expression of column[operator] if allowed by the column declaration
+func compileFilterOperator(column string, operator string, declaration string, values []string) (clause.Expression, error) {
+	if !filterAllows(declaration, operator) {
+		return nil, &FilterError{Column: column, Operator: operator, Reason: "operator not allowed"}
+	}
+	op, ok := FilterOperators[operator]
+	if !ok {
+		return nil, &FilterError{Column: column, Operator: operator, Reason: "unknown operator"}
+	}
+	if len(values) == 0 {
+		return nil, &FilterError{Column: column, Operator: operator, Reason: "missing value"}
+	}
+	sql, args, err := op(column, values)
+	if err != nil {
+		return nil, &FilterError{Column: column, Operator: operator, Reason: err.Error()}
+	}
+	return clause.Expr{SQL: sql, Vars: args}, nil
+}
+
+//plainFilter return the where expression of a plain column=value filter with the first declared mode of the column :
+//in, stringlike, year or an equality
+func plainFilter(column string, declaration string, values []string) clause.Expression {
+	switch strings.TrimSpace(strings.Split(declaration, ",")[0]) {
+	case "in":
+		return clause.Expr{SQL: column + " IN (?)", Vars: []interface{}{values}}
+	case "stringlike":
+		return clause.Expr{SQL: column + " LIKE ?", Vars: []interface{}{"%" + values[0] + "%"}}
+	case "year":
+		return clause.Expr{SQL: "YEAR(" + column + ") = ?", Vars: []interface{}{values[0]}}
+	}
+	return clause.Expr{SQL: column + " = ?", Vars: []interface{}{values[0]}}
+}
+
+//parseFilterKey split a column[operator] url key
+func parseFilterKey(key string) (string, string, bool) {
+	start := strings.Index(key, "[")
+	if start <= 0 || !strings.HasSuffix(key, "]") {
+		return "", "", false
+	}
+	return key[:start], key[start+1 : len(key)-1], true
+}
+
+//filterAllows check the operator is in the comma separated declaration of FilterColumns
+func filterAllows(declaration string, operator string) bool {
+	for _, v := range strings.Split(declaration, ",") {
+		if strings.TrimSpace(v) == operator {
+			return true
+		}
+	}
+	return false
+}
+
+func splitFilterValues(values []string) []string {
+	ret := []string{}
+	for _, v := range values {
+		ret = append(ret, strings.Split(v, ",")...)
+	}
+	return ret
+}
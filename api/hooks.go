@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+)
+
+//HookError an error of a hook aborting the request with its http status
+//and an optional problem code, the code of the status by default
+type HookError struct {
+	Status  int
+	Message string
+	Code    string
+}
+
+func (e *HookError) Error() string {
+	return e.Message
+}
+
+//NewHookError return an error aborting the request with status
+func NewHookError(status int, message string) *HookError {
+	return &HookError{Status: status, Message: message}
+}
+
+//Hooks lifecycle hooks of a model, the Before and After write hooks run in the transaction of the write
+//an error abort the request and rollback the transaction, a *HookError give the http status else 500
+type Hooks struct {
+	BeforeCreate func(r *http.Request, tx *gorm.DB, data interface{}) error
+	AfterCreate  func(r *http.Request, tx *gorm.DB, data interface{}) error
+	BeforeUpdate func(r *http.Request, tx *gorm.DB, old interface{}, data interface{}) error
+	AfterUpdate  func(r *http.Request, tx *gorm.DB, data interface{}) error
+	BeforeDelete func(r *http.Request, tx *gorm.DB, data interface{}) error
+	AfterDelete  func(r *http.Request, tx *gorm.DB, data interface{}) error
+	BeforeList   func(r *http.Request, req *gorm.DB) (*gorm.DB, error)
+	AfterRead    func(r *http.Request, data interface{}) error //on each read object, alone or in a list
+}
+
+//registered hooks by model type
+var hooks = map[reflect.Type]Hooks{}
+
+//RegisterHooks set the default hooks of the model, before serving the routes
+//they are used by all the routes of the model built without their own Hooks
+func RegisterHooks(model Validation, h Hooks) {
+	hooks[reflect.TypeOf(model)] = h
+}
+
+//GetHooks return the default hooks of the model
+func GetHooks(model interface{}) Hooks {
+	return hooks[reflect.TypeOf(model)]
+}
+
+type routeHooksKey struct{}
+
+//routeHooks the hooks of the routes of a builder, for its model only
+type routeHooks struct {
+	model reflect.Type
+	hooks Hooks
+}
+
+//withHooks return r with the hooks of the routes of model
+func withHooks(r *http.Request, model interface{}, h Hooks) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeHooksKey{}, routeHooks{model: reflect.TypeOf(model), hooks: h}))
+}
+
+//hooksOf return the hooks of model for r : the ones of its route, else the registered ones
+func hooksOf(r *http.Request, model interface{}) Hooks {
+	if v, ok := r.Context().Value(routeHooksKey{}).(routeHooks); ok && v.model == reflect.TypeOf(model) {
+		return v.hooks
+	}
+	return GetHooks(model)
+}
+
+//writeObject run write and its hooks in a transaction, with the audit entry
+//old is the object before an update
+func writeObject(r *http.Request, action string, old Validation, data Validation, changes []map[string]interface{}, write func(tx *gorm.DB, data Validation) error) error {
+	return GetDB().Transaction(func(tx *gorm.DB) error {
+		return writeWithHooks(tx, r, action, old, data, changes, write)
+	})
+}
+
+//writeWithHooks run the Before hook, write, store the audit entry and run the After hook
+func writeWithHooks(tx *gorm.DB, r *http.Request, action string, old Validation, data Validation, changes []map[string]interface{}, write func(tx *gorm.DB, data Validation) error) error {
+	h := hooksOf(r, data)
+	var err error
+	switch {
+	case action == AuditCreate && h.BeforeCreate != nil:
+		err = h.BeforeCreate(r, tx, data)
+	case (action == AuditUpdate || action == AuditRevert || action == AuditRestore) && h.BeforeUpdate != nil:
+		err = h.BeforeUpdate(r, tx, old, data)
+	case action == AuditDelete && h.BeforeDelete != nil:
+		err = h.BeforeDelete(r, tx, data)
+	}
+	if err != nil {
+		return err
+	}
+	if err := write(tx, data); err != nil {
+		return err
+	}
+	if err := recordAudit(tx, r, data, action, changes); err != nil {
+		return err
+	}
+	switch {
+	case action == AuditCreate && h.AfterCreate != nil:
+		err = h.AfterCreate(r, tx, data)
+	case (action == AuditUpdate || action == AuditRevert || action == AuditRestore) && h.AfterUpdate != nil:
+		err = h.AfterUpdate(r, tx, data)
+	case action == AuditDelete && h.AfterDelete != nil:
+		err = h.AfterDelete(r, tx, data)
+	}
+	return err
+}
+
+//cloneObject return a copy of data
+func cloneObject(data Validation) Validation {
+	v := reflect.New(reflect.TypeOf(data).Elem())
+	v.Elem().Set(reflect.ValueOf(data).Elem())
+	return v.Interface().(Validation)
+}
+
+//afterRead run the AfterRead hook on data, or on each element of a slice
+func afterRead(r *http.Request, model interface{}, data interface{}) error {
+	h := hooksOf(r, model)
+	if h.AfterRead == nil {
+		return nil
+	}
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Slice {
+		return h.AfterRead(r, data)
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := h.AfterRead(r, v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//beforeList run the BeforeList hook on the list query
+func beforeList(r *http.Request, model interface{}, req *gorm.DB) (*gorm.DB, error) {
+	if h := hooksOf(r, model); h.BeforeList != nil {
+		return h.BeforeList(r, req)
+	}
+	return req, nil
+}
+
+//respondHookError respond the problem of a hook error else 500
+func respondHookError(w http.ResponseWriter, r *http.Request, err error) {
+	if p, ok := hookProblem(err); ok {
+		utils.RespondError(w, r, p)
+		return
+	}
+	utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+}
+
+//hookProblem return the problem of a *HookError
+func hookProblem(err error) (*utils.Problem, bool) {
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) {
+		return nil, false
+	}
+	if hookErr.Code != "" {
+		return utils.NewProblem(hookErr.Status, hookErr.Code, hookErr.Message), true
+	}
+	return utils.StatusProblem(hookErr.Status, hookErr.Message), true
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//MaxImportRows maximum number of rows of an import
+var MaxImportRows = 10000
+
+//Actions of the rows of an import
+const (
+	ImportCreated  = "created"
+	ImportUpdated  = "updated"
+	ImportRejected = "rejected"
+)
+
+//ImportResult result of a row of an import
+type ImportResult struct {
+	Line   int    `json:"line"`
+	Action string `json:"action"`
+	Status int    `json:"status"`
+	Key    string `json:"key,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+//importRow a row of the body as a json document, or the error of the row
+type importRow struct {
+	line int
+	doc  map[string]interface{}
+	err  error
+}
+
+//GenericImport create an object for each row of a csv (text/csv) or ndjson (application/x-ndjson) body
+//?mode=upsert update the object of the same key instead (checked with fu), ?dry_run=true only validate the rows
+//each row is saved on its own and reported as created, updated or rejected
+func GenericImport(w http.ResponseWriter, r *http.Request, data Validation, fc func(r *http.Request, data interface{}) bool, fu func(r *http.Request, data interface{}, data2 interface{}) bool) {
+	upsert := false
+	switch r.FormValue("mode") {
+	case "", "insert":
+	case "upsert":
+		upsert = true
+	default:
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid mode, insert or upsert expected"))
+		return
+	}
+	dryRun := r.FormValue("dry_run") == "true"
+	fields, err := keyFields(data)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Import not available"))
+		return
+	}
+	rows, err := readImport(r, data)
+	if errors.Is(err, utils.ErrUnsupportedMediaType) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusUnsupportedMediaType, utils.CodeUnsupportedMediaType, "Import must be text/csv or application/x-ndjson"))
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
+		return
+	}
+	results := make([]*ImportResult, len(rows))
+	imported := 0
+	for i, row := range rows {
+		results[i] = importObject(r, data, row, upsert, dryRun, fields, fc, fu)
+		if results[i].Action != ImportRejected {
+			imported++
+		}
+	}
+	message := fmt.Sprintf("%d/%d rows imported", imported, len(rows))
+	if dryRun {
+		message = fmt.Sprintf("%d/%d rows valid, nothing saved", imported, len(rows))
+	}
+	resp := utils.Message(imported == len(rows), message)
+	resp["data"] = results
+	if imported == len(rows) {
+		utils.Respond(w, resp)
+		return
+	}
+	utils.RespondCode(w, resp, http.StatusMultiStatus)
+}
+
+//importObject validate and save a row, as a new object or as the update of the object of its key in upsert mode
+func importObject(r *http.Request, data Validation, row importRow, upsert bool, dryRun bool, fields []*schema.Field,
+	fc func(r *http.Request, data interface{}) bool, fu func(r *http.Request, data interface{}, data2 interface{}) bool) *ImportResult {
+	result := &ImportResult{Line: row.line, Action: ImportCreated, Status: http.StatusCreated}
+	if row.err != nil {
+		return result.reject(utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, row.err.Error()))
+	}
+	var loaded Validation
+	if values, missing := patchKey(row.doc, fields); missing == "" { //without key the row is created
+		tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+		if err := tmp.FindFromRequest(withKeyValues(r, tmp, values)); err == nil {
+			if !upsert { //checked before saving so the dry runs report it too
+				return result.reject(utils.NewProblem(http.StatusConflict, utils.CodeConflict, "Key "+strings.Join(values, "/")+" already exists"))
+			}
+			loaded = tmp
+		}
+	}
+
+	var old Validation
+	var changes []map[string]interface{}
+	action, write := AuditCreate, func(tx *gorm.DB, data Validation) error {
+		return tx.Create(data).Error
+	}
+	if loaded == nil {
+		tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+		b, _ := json.Marshal(row.doc)
+		if err := json.Unmarshal(b, tmp); err != nil {
+			return result.reject(utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
+		}
+		setUserEmitter(r, tmp)
+		if val, ok := tmp.Validate(); !ok {
+			return result.reject(utils.ValidationProblem(val))
+		}
+		if !fc(r, tmp) {
+			return result.reject(utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+		}
+		loaded = tmp
+	} else {
+		doc, err := toJSONDocument(loaded)
+		if err != nil {
+			return result.reject(utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Data Error"))
+		}
+		old = cloneObject(loaded)
+		var p *utils.Problem
+		if changes, p = patchObject(r, loaded, applyMergePatch(doc, row.doc), fu); p != nil {
+			return result.reject(p)
+		}
+		action, write = AuditUpdate, saveObject
+		result.Action, result.Status = ImportUpdated, http.StatusOK
+	}
+	if !dryRun {
+		if err := writeObject(r, action, old, loaded, changes, write); err != nil {
+			return result.reject(saveError(err))
+		}
+	}
+	result.Key = primaryKey(loaded)
+	return result
+}
+
+//reject set the status, code and error of a rejected row from its problem
+func (i *ImportResult) reject(p *utils.Problem) *ImportResult {
+	i.Action = ImportRejected
+	i.Status = p.Status
+	i.Code = p.Code
+	i.Error = p.Error()
+	return i
+}
+
+//readImport read the rows of the body according to its Content-Type
+func readImport(r *http.Request, data Validation) ([]importRow, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return readCSVImport(r.Body, data)
+	case utils.NDJSONMediaType, "application/ndjson":
+		return readNDJSONImport(r.Body)
+	}
+	return nil, utils.ErrUnsupportedMediaType
+}
+
+//readNDJSONImport read a json object by line, empty lines are skipped
+func readNDJSONImport(body io.Reader) ([]importRow, error) {
+	rows := []importRow{}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, fmt.Errorf("more than %d rows", MaxImportRows)
+		}
+		row := importRow{line: line}
+		if err := decodeJSONDocument(scanner.Bytes(), &row.doc); err != nil || row.doc == nil {
+			row.err = errors.New("invalid json object")
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+//readCSVImport read the rows of a csv with a header, the values are converted to the type of the field of their column
+//an empty value is the zero value of the field
+func readCSVImport(body io.Reader, data Validation) ([]importRow, error) {
+	sch, err := parseSchema(data)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("csv header expected")
+	}
+	columns := make([]*schema.Field, len(header))
+	for i, h := range header {
+		if columns[i] = importField(sch, data, strings.TrimSpace(h)); columns[i] == nil {
+			return nil, fmt.Errorf("unknown column %q", h)
+		}
+	}
+	rows := []importRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(rows) == MaxImportRows {
+			return nil, fmt.Errorf("more than %d rows", MaxImportRows)
+		}
+		row := importRow{line: line}
+		if err != nil {
+			row.err = fmt.Errorf("%d columns expected", len(columns))
+		} else {
+			row.doc, row.err = csvDocument(data, columns, record)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+//csvDocument return the json document of the columns of a csv record
+func csvDocument(data Validation, columns []*schema.Field, record []string) (map[string]interface{}, error) {
+	tmp := reflect.New(reflect.TypeOf(data).Elem())
+	for i, v := range record {
+		if v == "" {
+			continue
+		}
+		if err := columns[i].Set(tmp.Elem(), v); err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s", v, jsonKey(columns[i]))
+		}
+	}
+	full := map[string]interface{}{}
+	b, err := json.Marshal(tmp.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeJSONDocument(b, &full); err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	for _, f := range columns {
+		doc[jsonKey(f)] = full[jsonKey(f)]
+	}
+	return doc, nil
+}
+
+//importField return the field of a csv header : an export header, a struct field, a json key or a db column
+func importField(sch *schema.Schema, data Validation, header string) *schema.Field {
+	var f *schema.Field
+	if v, ok := data.(Exportable); ok {
+		for _, c := range v.ExportColumns() {
+			if c.Header == header {
+				f = sch.LookUpField(c.Field)
+			}
+		}
+	}
+	if f == nil {
+		f = sch.LookUpField(header)
+	}
+	for _, v := range sch.Fields {
+		if f == nil && jsonKey(v) == header {
+			f = v
+		}
+	}
+	if f == nil || f.DBName == "" || jsonKey(f) == "-" {
+		return nil
+	}
+	return f
+}
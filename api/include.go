@@ -0,0 +1,113 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//MaxIncludeDepth maximum depth of an ?include= association path
+var MaxIncludeDepth = 3
+
+//ErrIncludeForbidden returned when the user lack the rights of an included association
+var ErrIncludeForbidden = errors.New("association forbidden")
+
+//GetIncludes return the gorm preloads of ?include=owner,items.product checked against Includes and the user rights
+func GetIncludes(r *http.Request, data Validation) ([]string, error) {
+	include := r.FormValue("include")
+	if include == "" {
+		return nil, nil
+	}
+	includable, ok := data.(Includable)
+	if !ok {
+		return nil, fmt.Errorf("include not available on %s", data.TableName())
+	}
+	allowed := map[string]utils.RightBits{}
+	for k, v := range includable.Includes() {
+		allowed[strings.ToLower(k)] = v
+	}
+	sch, err := parseSchema(data)
+	if err != nil {
+		return nil, err
+	}
+	preloads := []string{}
+	for _, v := range strings.Split(include, ",") {
+		path := strings.Split(strings.ToLower(strings.TrimSpace(v)), ".")
+		if len(path) > MaxIncludeDepth {
+			return nil, fmt.Errorf("include %q deeper than %d", v, MaxIncludeDepth)
+		}
+		if _, ok := allowed[strings.Join(path, ".")]; !ok {
+			return nil, fmt.Errorf("include %q not allowed", v)
+		}
+		for i := range path { //rights of items are needed to load items.product
+			if rights, ok := allowed[strings.Join(path[:i+1], ".")]; ok && rights != utils.NoRight && !utils.HasRightsRequest(r, rights) {
+				return nil, fmt.Errorf("%w : %s", ErrIncludeForbidden, v)
+			}
+		}
+		preload, err := associationPath(sch, path)
+		if err != nil {
+			return nil, err
+		}
+		preloads = append(preloads, preload)
+	}
+	return preloads, nil
+}
+
+//associationPath return the gorm association path (Items.Product) of the lower case path
+func associationPath(sch *schema.Schema, path []string) (string, error) {
+	names := []string{}
+	for _, name := range path {
+		var found *schema.Relationship
+		for _, rel := range sch.Relationships.Relations {
+			if strings.ToLower(rel.Name) == name || strings.ToLower(jsonKey(rel.Field)) == name {
+				found = rel
+				break
+			}
+		}
+		if found == nil {
+			return "", fmt.Errorf("unknown association %q", name)
+		}
+		names = append(names, found.Name)
+		sch = found.FieldSchema
+	}
+	return strings.Join(names, "."), nil
+}
+
+//IncludeScope return the scope preloading associations from GetIncludes
+func IncludeScope(preloads []string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, v := range preloads {
+			db = db.Preload(v)
+		}
+		return db
+	}
+}
+
+//includeLinks add to the selection the columns needed by the first level of the preloads
+func (s *FieldSelection) includeLinks(preloads []string) {
+	if s == nil {
+		return
+	}
+	for _, v := range preloads {
+		rel, ok := s.schema.Relationships.Relations[strings.Split(v, ".")[0]]
+		if !ok {
+			continue
+		}
+		s.addLinks(rel)
+		s.keys[jsonKey(rel.Field)] = nil
+	}
+}
+
+//respondIncludeError respond 403 on forbidden associations else 400
+func respondIncludeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrIncludeForbidden) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, err.Error()))
+		return
+	}
+	utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidFilter, err.Error()))
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm/schema"
+)
+
+//Patterns of the url vars of the keys
+const (
+	KeyInt  = "[0-9]+"
+	KeyUUID = "[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}"
+	KeyULID = "[0-9A-HJKMNP-TV-Za-hjkmnp-tv-z]{26}"
+	KeySlug = "[a-zA-Z0-9_-]+"
+)
+
+//Key a column of the primary key of the routes
+type Key struct {
+	Name    string //url var, ex: org for /api/table/{org}
+	Column  string //db column
+	Pattern string //regexp of the value : KeyInt, KeyUUID, KeyULID, KeySlug, ...
+}
+
+//primaryKeys return the keys of data, by default the integer {id}
+func primaryKeys(data interface{}) []Key {
+	if v, ok := data.(Keyed); ok {
+		return v.PrimaryKeys()
+	}
+	column := "id"
+	if sch, err := parseSchema(data); err == nil && sch.PrioritizedPrimaryField != nil {
+		column = sch.PrioritizedPrimaryField.DBName
+	}
+	return []Key{{Name: "id", Column: column, Pattern: KeyInt}}
+}
+
+//keyPattern return the url pattern of the keys : /{id:[0-9]+} or /{org:...}/{code:...}
+func keyPattern(data interface{}) string {
+	pattern := ""
+	for _, k := range primaryKeys(data) {
+		pattern += "/{" + k.Name + ":" + k.Pattern + "}"
+	}
+	return pattern
+}
+
+//withKey return the request with the key conditions of the url vars, used by DefaultFindFromRequest
+//the request of a model without Keyed is unchanged and use the {id} url var
+func withKey(r *http.Request, data interface{}) *http.Request {
+	if _, ok := data.(Keyed); !ok {
+		return r
+	}
+	vars := mux.Vars(r)
+	conditions := map[string]interface{}{}
+	for _, k := range primaryKeys(data) {
+		conditions[k.Column] = vars[k.Name]
+	}
+	return utils.WithKeyConditions(r, conditions)
+}
+
+//withKeyValues return the request with the values of the keys as url vars
+func withKeyValues(r *http.Request, data interface{}, values []string) *http.Request {
+	vars := map[string]string{}
+	for k, v := range mux.Vars(r) {
+		vars[k] = v
+	}
+	for i, k := range primaryKeys(data) {
+		if i < len(values) {
+			vars[k.Name] = values[i]
+		}
+	}
+	return withKey(mux.SetURLVars(r, vars), data)
+}
+
+//keyFields return the schema fields of the keys of data
+func keyFields(data interface{}) ([]*schema.Field, error) {
+	sch, err := parseSchema(data)
+	if err != nil {
+		return nil, err
+	}
+	fields := []*schema.Field{}
+	for _, k := range primaryKeys(data) {
+		f := sch.LookUpField(k.Column)
+		if f == nil {
+			return nil, fmt.Errorf("unknown key column %s", k.Column)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+//primaryKey return the primary key of data as a string, the values of a composite key are joined by /
+func primaryKey(data interface{}) string {
+	fields, err := keyFields(data)
+	if err != nil {
+		return ""
+	}
+	values := []string{}
+	for _, f := range fields {
+		v, _ := f.ValueOf(reflect.Indirect(reflect.ValueOf(data)))
+		values = append(values, fmt.Sprint(v))
+	}
+	return strings.Join(values, "/")
+}
+
+//isNewObject return if all the primary fields of data are empty
+func isNewObject(sch *schema.Schema, data interface{}) bool {
+	for _, f := range sch.PrimaryFields {
+		if _, isZero := f.ValueOf(reflect.Indirect(reflect.ValueOf(data))); !isZero {
+			return false
+		}
+	}
+	return true
+}
+
+//primaryConditions return the values of the primary fields of data by column
+func primaryConditions(sch *schema.Schema, data interface{}) map[string]interface{} {
+	conditions := map[string]interface{}{}
+	for _, f := range sch.PrimaryFields {
+		conditions[f.DBName], _ = f.ValueOf(reflect.Indirect(reflect.ValueOf(data)))
+	}
+	return conditions
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/mux"
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//resourceParent the parent of a nested resource : /api/parent/{param}/table
+type resourceParent struct {
+	model  Validation
+	param  string //url var of the parent key
+	column string //foreign key column of the child
+	f      func(r *http.Request, data interface{}) bool
+}
+
+//parentScope the foreign key of the objects of a nested resource, set on the request by its routes
+type parentScope struct {
+	table string
+	field *schema.Field //foreign key of the child
+	value interface{}   //primary key of the parent
+}
+
+type parentScopeKey struct{}
+
+//Parent nest the routes under /api/parent/{param}/table : the objects are filtered by the foreign key column
+//which is set on create, the parent must exist and f (read rights function of the parent) must allow it
+//the parent key is the first of its keys
+func (b *ResourceBuilder) Parent(parent Validation, param string, foreignKey string, f func(r *http.Request, data interface{}) bool) *ResourceBuilder {
+	b.crud.parent = &resourceParent{model: parent, param: param, column: foreignKey, f: f}
+	return b
+}
+
+//WithParent nest the routes of c under their parent P like ResourceBuilder.Parent, f get the parent :
+//
+//	api.WithParent(api.NewCRUD[Employee](), "cid", "company_id", func(r *http.Request, company *Company) bool { ... })
+func WithParent[P any, PP Model[P], T Validation](c *CRUD[T], param string, foreignKey string, f func(r *http.Request, parent PP) bool) *CRUD[T] {
+	c.parent = &resourceParent{model: PP(new(P)), param: param, column: foreignKey, f: func(r *http.Request, data interface{}) bool {
+		return f(r, data.(PP))
+	}}
+	return c
+}
+
+//pattern return the url of the parent : parent/{param}/
+func (p *resourceParent) pattern() string {
+	if p == nil {
+		return ""
+	}
+	return p.model.TableName() + "/{" + p.param + ":" + primaryKeys(p.model)[0].Pattern + "}/"
+}
+
+//scope find the parent of the request and return the request with the foreign key scope
+func (p *resourceParent) scope(w http.ResponseWriter, r *http.Request, child Validation) (*http.Request, bool) {
+	sch, err := parseSchema(child)
+	var field *schema.Field
+	if err == nil {
+		field = sch.LookUpField(p.column)
+	}
+	if field == nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Unknown parent key "+p.column))
+		return r, false
+	}
+	parent := reflect.New(reflect.TypeOf(p.model).Elem()).Interface().(Validation)
+	err = parent.FindFromRequest(withKeyValues(r, parent, []string{mux.Vars(r)[p.param]}))
+	if !p.f(r, parent) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+		return r, false
+	}
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Parent Not Found"))
+		return r, false
+	}
+	scope := &parentScope{table: sch.Table, field: field, value: parentKey(parent)}
+	r = r.WithContext(context.WithValue(r.Context(), parentScopeKey{}, scope))
+	return utils.WithQueryScopes(r, scope.Scope), true
+}
+
+//parentKey return the primary key value of the parent, referenced by the foreign key
+func parentKey(parent Validation) interface{} {
+	fields, err := keyFields(parent)
+	if err != nil {
+		return nil
+	}
+	field := fields[0]
+	if sch, err := parseSchema(parent); err == nil && sch.PrioritizedPrimaryField != nil {
+		field = sch.PrioritizedPrimaryField
+	}
+	v, _ := field.ValueOf(reflect.ValueOf(parent).Elem())
+	return v
+}
+
+//parentOf return the parent scope of the request of a nested resource
+func parentOf(r *http.Request) *parentScope {
+	scope, _ := r.Context().Value(parentScopeKey{}).(*parentScope)
+	return scope
+}
+
+//Scope filter the objects of the parent
+func (s *parentScope) Scope(db *gorm.DB) *gorm.DB {
+	if s == nil {
+		return db
+	}
+	return db.Where(s.table+"."+s.field.DBName+" = ?", s.value)
+}
+
+//setParentKey set the foreign key of data to the parent of the request
+func setParentKey(r *http.Request, data interface{}) {
+	if s := parentOf(r); s != nil {
+		s.field.Set(reflect.ValueOf(data).Elem(), s.value)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+//Sort types returned by SortAble.SortTypes, columns not declared are sorted as stored
+const (
+	SortNumeric = "numeric"
+	SortText    = "text"
+	SortNoCase  = "nocase"
+	//SortCollate prefix the name of a collation, ex : SortCollate + "utf8mb4_unicode_ci"
+	SortCollate = "collate:"
+)
+
+//SortKey a column to sort on
+type SortKey struct {
+	Column string
+	Desc   bool
+	Type   string
+}
+
+//Expression return the sql sorting target (a column or a ? placeholder) as declared by the key type
+func (k SortKey) Expression(db *gorm.DB, target string) string {
+	switch {
+	case k.Type == SortNumeric:
+		return "CAST(" + target + " AS DECIMAL(65,30))"
+	case k.Type == SortText:
+		//mysql can only cast to CHAR when postgres would truncate it to one character
+		if db.Dialector.Name() == "mysql" {
+			return "CAST(" + target + " AS CHAR)"
+		}
+		return "CAST(" + target + " AS VARCHAR)"
+	case k.Type == SortNoCase:
+		return "LOWER(" + target + ")"
+	case strings.HasPrefix(k.Type, SortCollate):
+		return target + " COLLATE " + strings.TrimPrefix(k.Type, SortCollate)
+	}
+	return target
+}
+
+//OrderBy return the ORDER BY part of the key
+func (k SortKey) OrderBy(db *gorm.DB) string {
+	if k.Desc {
+		return k.Expression(db, k.Column) + " DESC"
+	}
+	return k.Expression(db, k.Column) + " ASC"
+}
+
+//GetSort return the sort keys of ?sort=-created_at,name (- for descending) or of the legacy ?order=name_asc
+//columns must be exactly one of OrderColumns
+func GetSort(r *http.Request, data Validation) ([]SortKey, error) {
+	types := map[string]string{}
+	if v, ok := data.(SortAble); ok {
+		types = v.SortTypes()
+	}
+	allowed := map[string]bool{}
+	for _, v := range data.OrderColumns() {
+		allowed[v] = true
+	}
+	keys := []SortKey{}
+	if sort := r.FormValue("sort"); sort != "" {
+		used := map[string]bool{}
+		for _, v := range strings.Split(sort, ",") {
+			v = strings.TrimSpace(v)
+			key := SortKey{Column: strings.TrimPrefix(strings.TrimPrefix(v, "-"), "+"), Desc: strings.HasPrefix(v, "-")}
+			if !allowed[key.Column] { //avoid sql injection on orders
+				return nil, fmt.Errorf("can't sort on %q", key.Column)
+			}
+			if used[key.Column] {
+				return nil, fmt.Errorf("column %q sorted twice", key.Column)
+			}
+			used[key.Column] = true
+			key.Type = types[key.Column]
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+	//legacy column_asc or column_desc, ignored if invalid
+	if order := r.FormValue("order"); order != "" {
+		i := strings.LastIndex(order, "_")
+		if i > 0 && allowed[order[:i]] && (order[i+1:] == "asc" || order[i+1:] == "desc") {
+			keys = append(keys, SortKey{Column: order[:i], Desc: order[i+1:] == "desc", Type: types[order[:i]]})
+		}
+	}
+	return keys, nil
+}
+
+//applySort add the keys ordering to req
+func applySort(req *gorm.DB, keys []SortKey) *gorm.DB {
+	for _, k := range keys {
+		req = req.Order(k.OrderBy(req))
+	}
+	return req
+}
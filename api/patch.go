@@ -0,0 +1,399 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/loupzeur/go-crud-api/utils"
+)
+
+//ErrPatchTestFailed returned when a json patch test operation doesn't match
+var ErrPatchTestFailed = errors.New("test operation failed")
+
+//jsonPatchOperation an operation of RFC 6902
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+//GenericPatch apply a json merge patch (RFC 7396) or json patch (RFC 6902) on the object
+//unlike GenericUpdate, fields can be set to their zero value
+func GenericPatch(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}, data2 interface{}) bool) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/merge-patch+json" && contentType != "application/json-patch+json" && contentType != "application/json" {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusUnsupportedMediaType, utils.CodeUnsupportedMediaType, "Unsupported patch format"))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Error : "+err.Error()))
+		return
+	}
+	tmp1 := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+	if err := tmp1.FindFromRequest(r); err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+		return
+	}
+	if !ifMatch(r, tmp1) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusPreconditionFailed, utils.CodePreconditionFailed, "Precondition Failed"))
+		return
+	}
+	doc, err := toJSONDocument(tmp1)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Data Error"))
+		return
+	}
+
+	var patched interface{}
+	if contentType == "application/json-patch+json" {
+		ops := []jsonPatchOperation{}
+		if err := json.Unmarshal(body, &ops); err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Invalid patch : "+err.Error()))
+			return
+		}
+		patched, err = applyJSONPatch(doc, ops)
+	} else {
+		var patch interface{}
+		if err = decodeJSONDocument(body, &patch); err != nil {
+			utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidBody, "Invalid patch : "+err.Error()))
+			return
+		}
+		patched = applyMergePatch(doc, patch)
+	}
+	if errors.Is(err, ErrPatchTestFailed) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusConflict, utils.CodeConflict, err.Error()))
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusUnprocessableEntity, utils.CodeInvalidBody, err.Error()))
+		return
+	}
+
+	old := cloneObject(tmp1)
+	changes, p := patchObject(r, tmp1, patched, f)
+	if p != nil {
+		utils.RespondError(w, r, p)
+		return
+	}
+	if err = writeObject(r, AuditUpdate, old, tmp1, changes, saveObject); err != nil {
+		respondSaveError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(tmp1))
+	resp := utils.Message(true, "success")
+	resp["data"] = tmp1
+	utils.Respond(w, resp)
+}
+
+//patchObject apply the patched document on the loaded object once validated and allowed by f
+//return the difference, or the problem on failure
+func patchObject(r *http.Request, loaded Validation, patched interface{}, f func(r *http.Request, data interface{}, data2 interface{}) bool) ([]map[string]interface{}, *utils.Problem) {
+	tmp2 := reflect.New(reflect.TypeOf(loaded).Elem()).Interface().(Validation)
+	if err := fromJSONDocument(loaded, patched, tmp2); err != nil {
+		return nil, utils.NewProblem(http.StatusUnprocessableEntity, utils.CodeInvalidBody, "Error : "+err.Error())
+	}
+	if err := keepIdentity(loaded, tmp2); err != nil {
+		return nil, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Data Error")
+	}
+	setUserEmitter(r, tmp2)
+	if val, ok := tmp2.Validate(); !ok {
+		return nil, utils.ValidationProblem(val)
+	}
+	if !f(r, loaded, tmp2) {
+		return nil, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden")
+	}
+	changes, err := copyFields(loaded, tmp2, true)
+	if err != nil {
+		return nil, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Data Error")
+	}
+	return changes, nil
+}
+
+//keepIdentity set back on patched the primary key and the version of loaded, a patch can't move the object on another row
+func keepIdentity(loaded Validation, patched Validation) error {
+	sch, err := parseSchema(loaded)
+	if err != nil {
+		return err
+	}
+	fields := sch.PrimaryFields
+	if field := versionField(loaded, sch); field != nil {
+		fields = append(fields[:len(fields):len(fields)], field)
+	}
+	for _, f := range fields {
+		v, _ := f.ValueOf(reflect.ValueOf(loaded).Elem())
+		if err := f.Set(reflect.ValueOf(patched).Elem(), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//toJSONDocument return data as a generic json document
+func toJSONDocument(data interface{}) (interface{}, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	return doc, decodeJSONDocument(b, &doc)
+}
+
+func decodeJSONDocument(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+//fromJSONDocument fill dst with src then with the patched document
+//serialized fields are reset first so removed keys get their zero value
+func fromJSONDocument(src interface{}, doc interface{}, dst interface{}) error {
+	dstV := reflect.ValueOf(dst).Elem()
+	dstV.Set(reflect.ValueOf(src).Elem())
+	resetJSONFields(dstV)
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+//resetJSONFields set to zero fields of v which are serialized
+func resetJSONFields(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			resetJSONFields(v.Field(i))
+			continue
+		}
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		v.Field(i).Set(reflect.Zero(f.Type))
+	}
+}
+
+//applyMergePatch apply a RFC 7396 patch on target
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = applyMergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+//applyJSONPatch apply RFC 6902 operations on doc
+func applyJSONPatch(doc interface{}, ops []jsonPatchOperation) (interface{}, error) {
+	var err error
+	for i, op := range ops {
+		var value interface{}
+		if len(op.Value) > 0 {
+			if err := decodeJSONDocument(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("operation %d : %s", i, err.Error())
+			}
+		}
+		switch op.Op {
+		case "add":
+			if len(op.Value) == 0 {
+				return nil, fmt.Errorf("operation %d : missing value", i)
+			}
+			doc, err = jsonPointerAdd(doc, op.Path, value, false)
+		case "replace":
+			if len(op.Value) == 0 {
+				return nil, fmt.Errorf("operation %d : missing value", i)
+			}
+			doc, err = jsonPointerAdd(doc, op.Path, value, true)
+		case "remove":
+			doc, _, err = jsonPointerRemove(doc, op.Path)
+		case "move":
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("operation %d : can't move %s in itself", i, op.From)
+			}
+			var moved interface{}
+			if doc, moved, err = jsonPointerRemove(doc, op.From); err == nil {
+				doc, err = jsonPointerAdd(doc, op.Path, moved, false)
+			}
+		case "copy":
+			var copied interface{}
+			if copied, err = jsonPointerGet(doc, op.From); err == nil {
+				doc, err = jsonPointerAdd(doc, op.Path, copied, false)
+			}
+		case "test":
+			var current interface{}
+			if current, err = jsonPointerGet(doc, op.Path); err == nil && !jsonEqual(current, value) {
+				err = fmt.Errorf("%w on %s", ErrPatchTestFailed, op.Path)
+			}
+		default:
+			return nil, fmt.Errorf("operation %d : unknown op %q", i, op.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+//jsonPointer split a RFC 6901 pointer
+func jsonPointer(path string) ([]string, error) {
+	if path == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, v := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(v, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func jsonPointerGet(doc interface{}, path string) (interface{}, error) {
+	tokens, err := jsonPointer(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			val, ok := v[t]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", path)
+			}
+			doc = val
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("path %q not found", path)
+			}
+			doc = v[i]
+		default:
+			return nil, fmt.Errorf("path %q not found", path)
+		}
+	}
+	return doc, nil
+}
+
+//jsonPointerAdd add (or replace an existing) value at path and return the new document
+func jsonPointerAdd(doc interface{}, path string, value interface{}, replace bool) (interface{}, error) {
+	tokens, err := jsonPointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := jsonPointerGet(doc, path[:strings.LastIndex(path, "/")])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch v := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := v[last]; replace && !ok {
+			return nil, fmt.Errorf("path %q not found", path)
+		}
+		v[last] = value
+		return doc, nil
+	case []interface{}:
+		i := len(v)
+		if last != "-" {
+			if i, err = strconv.Atoi(last); err != nil || i < 0 || i > len(v) || (replace && i == len(v)) {
+				return nil, fmt.Errorf("path %q not found", path)
+			}
+		}
+		if replace {
+			v[i] = value
+			return doc, nil
+		}
+		v = append(v[:i], append([]interface{}{value}, v[i:]...)...)
+		return jsonPointerAdd(doc, path[:strings.LastIndex(path, "/")], v, true)
+	}
+	return nil, fmt.Errorf("path %q not found", path)
+}
+
+//jsonPointerRemove remove the value at path and return the new document and the removed value
+func jsonPointerRemove(doc interface{}, path string) (interface{}, interface{}, error) {
+	tokens, err := jsonPointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("can't remove the whole document")
+	}
+	removed, err := jsonPointerGet(doc, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	parentPath := path[:strings.LastIndex(path, "/")]
+	parent, _ := jsonPointerGet(doc, parentPath)
+	last := tokens[len(tokens)-1]
+	switch v := parent.(type) {
+	case map[string]interface{}:
+		delete(v, last)
+		return doc, removed, nil
+	case []interface{}:
+		i, _ := strconv.Atoi(last)
+		v = append(v[:i:i], v[i+1:]...)
+		doc, err = jsonPointerAdd(doc, parentPath, v, true)
+		return doc, removed, err
+	}
+	return nil, nil, fmt.Errorf("path %q not found", path)
+}
+
+//jsonEqual compare json values, numbers by value
+func jsonEqual(a interface{}, b interface{}) bool {
+	switch va := a.(type) {
+	case json.Number:
+		vb, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fa, _ := va.Float64()
+		fb, _ := vb.Float64()
+		return fa == fb
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for k, v := range va {
+			if !jsonEqual(v, vb[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for i := range va {
+			if !jsonEqual(va[i], vb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
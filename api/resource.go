@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+)
+
+//Verb a route of a resource, List, Get, Create, Update and Delete are routed once set on the builder
+//the other verbs once enabled, if the verbs in parentheses are set
+type Verb int
+
+//Verbs of a resource
+const (
+	List         Verb = iota //GET /api/table
+	Search                   //POST /api/table/search (List)
+	Get                      //GET /api/table/{id}
+	Create                   //POST /api/table
+	Update                   //PUT /api/table/{id}
+	Patch                    //PATCH /api/table/{id} (Update)
+	Delete                   //DELETE /api/table/{id}
+	Bulk                     //bulk routes, each one need its verb too (Create, Update and Delete)
+	Trash                    //GET /api/table/trash of SoftDeletable (Delete)
+	Restore                  //POST /api/table/{id}/restore of SoftDeletable (Delete)
+	History                  //GET /api/table/{id}/history of HistoryAble (Get)
+	Revert                   //POST /api/table/{id}/revert of HistoryAble (Update)
+	Associations             //GET (Get), POST, PUT and DELETE (Update) /api/table/{id}/association of Associable
+	Aggregate                //GET /api/table/aggregate of Aggregatable (List)
+	Export                   //GET /api/table/export and GET /api/table with a csv or xlsx Accept header (List)
+	Import                   //POST /api/table/import of a csv or ndjson body (Create, and Update for the upserts)
+)
+
+//ResourceBuilder build the routes of a model, it is the CRUD of the model with interface{} rights functions
+type ResourceBuilder struct {
+	crud *CRUD[Validation]
+}
+
+//Resource return a builder of the routes of model
+//List, Get, Create, Update and Delete are only routed once set, the other verbs once enabled
+func Resource(model Validation) *ResourceBuilder {
+	return &ResourceBuilder{crud: newCRUD(model)}
+}
+
+//List enable the list with its request function and rights, also used by search, aggregate, export and trash
+func (b *ResourceBuilder) List(freq func(r *http.Request, req *gorm.DB) *gorm.DB, rights utils.RightBits) *ResourceBuilder {
+	b.crud.List(freq, rights)
+	return b
+}
+
+//Get enable the read with its rights function and rights, also used by history
+func (b *ResourceBuilder) Get(f func(r *http.Request, data interface{}) bool, rights utils.RightBits) *ResourceBuilder {
+	b.crud.Get(func(r *http.Request, item Validation) bool {
+		return f(r, item)
+	}, rights)
+	return b
+}
+
+//Create enable the create with its rights function and rights, also used by import
+func (b *ResourceBuilder) Create(f func(r *http.Request, data interface{}) bool, rights utils.RightBits) *ResourceBuilder {
+	b.crud.Create(func(r *http.Request, item Validation) bool {
+		return f(r, item)
+	}, rights)
+	return b
+}
+
+//Update enable the update with its rights function and rights, also used by patch, revert, the associations edits and the upserts
+func (b *ResourceBuilder) Update(f func(r *http.Request, data interface{}, data2 interface{}) bool, rights utils.RightBits) *ResourceBuilder {
+	b.crud.Update(func(r *http.Request, old Validation, new Validation) bool {
+		return f(r, old, new)
+	}, rights)
+	return b
+}
+
+//Delete enable the delete with its rights function and rights, also used by trash and restore
+func (b *ResourceBuilder) Delete(f func(r *http.Request, data interface{}) bool, rights utils.RightBits) *ResourceBuilder {
+	b.crud.Delete(func(r *http.Request, item Validation) bool {
+		return f(r, item)
+	}, rights)
+	return b
+}
+
+//Enable add the routes of the verbs on top of List, Get, Create, Update and Delete
+func (b *ResourceBuilder) Enable(verbs ...Verb) *ResourceBuilder {
+	b.crud.Enable(verbs...)
+	return b
+}
+
+//Disable remove the routes of verbs
+func (b *ResourceBuilder) Disable(verbs ...Verb) *ResourceBuilder {
+	b.crud.Disable(verbs...)
+	return b
+}
+
+//Prefix set the url prefix : /api/prefix/table
+func (b *ResourceBuilder) Prefix(prefix string) *ResourceBuilder {
+	b.crud.Prefix(prefix)
+	return b
+}
+
+//Hooks set the hooks of the routes of the builder, instead of the hooks registered for the model
+func (b *ResourceBuilder) Hooks(h Hooks) *ResourceBuilder {
+	b.crud.hooks = &h
+	return b
+}
+
+//Action add a POST /api/table/{key}/name route calling f with the object, loaded with FindFromRequest
+func (b *ResourceBuilder) Action(name string, f func(w http.ResponseWriter, r *http.Request, data Validation), rights utils.RightBits) *ResourceBuilder {
+	b.crud.Action(name, f, rights)
+	return b
+}
+
+//Routes return the routes of the enabled verbs and of the actions, the objects are found by the keys of Keyed models else by {id}
+func (b *ResourceBuilder) Routes() utils.Routes {
+	return b.crud.Routes()
+}
+
+//GenericAction load the object and call f with it
+func GenericAction(w http.ResponseWriter, r *http.Request, data Validation, f func(w http.ResponseWriter, r *http.Request, data Validation)) {
+	tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+	if err := tmp.FindFromRequest(r); err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+		return
+	}
+	f(w, r, tmp)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+)
+
+//GenericRevert rebuild the object as it was after the audit entry ?to= by undoing the later changes
+//the result is validated, checked with the update rights function f and saved as a new audit entry
+//?preview=true return the object without saving it
+func GenericRevert(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}, data2 interface{}) bool) {
+	to, err := utils.ReadInt(r, "to", 0)
+	if err != nil || to <= 0 {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Invalid history id"))
+		return
+	}
+	tmp1 := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+	if err := tmp1.FindFromRequest(r); err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+		return
+	}
+	obj, ok := tmp1.(HistoryAble)
+	if !ok || !AuditEnabled {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "History not available"))
+		return
+	}
+	if !ifMatch(r, tmp1) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusPreconditionFailed, utils.CodePreconditionFailed, "Precondition Failed"))
+		return
+	}
+	req := GetDB().WithContext(r.Context()).Where("resource = ? AND object_id = ?", tmp1.TableName(), primaryKey(tmp1)).Session(&gorm.Session{})
+	if err := req.First(&AuditEntry{}, to).Error; err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "History entry not found"))
+		return
+	}
+	entries := []AuditEntry{}
+	if err := req.Where("id > ?", to).Order("id DESC").Find(&entries).Error; err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+		return
+	}
+
+	tmp2 := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+	reflect.ValueOf(tmp2).Elem().Set(reflect.ValueOf(tmp1).Elem())
+	if err := undoChanges(tmp2, obj.GetHistoryFields(), entries); err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusUnprocessableEntity, utils.CodeValidationFailed, err.Error()))
+		return
+	}
+	setUserEmitter(r, tmp2)
+	if val, ok := tmp2.Validate(); !ok {
+		utils.RespondError(w, r, utils.ValidationProblem(val))
+		return
+	}
+	if !f(r, tmp1, tmp2) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+		return
+	}
+	old := cloneObject(tmp1)
+	changes, err := copyFields(tmp1, tmp2, true)
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Data Error"))
+		return
+	}
+	if r.FormValue("preview") == "true" {
+		resp := utils.Message(true, "preview")
+		resp["data"] = tmp1
+		utils.Respond(w, resp)
+		return
+	}
+	if err = writeObject(r, AuditRevert, old, tmp1, changes, saveObject); err != nil {
+		respondSaveError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(tmp1))
+	resp := utils.Message(true, "success")
+	resp["data"] = tmp1
+	utils.Respond(w, resp)
+}
+
+//undoChanges set the old values of the entries, latest first, on data
+func undoChanges(data interface{}, historyFields map[string]string, entries []AuditEntry) error {
+	fields := map[string]string{} //history name to struct field
+	for k, v := range historyFields {
+		fields[v] = k
+	}
+	v := reflect.Indirect(reflect.ValueOf(data))
+	for _, entry := range entries {
+		if entry.Action != AuditUpdate && entry.Action != AuditRevert {
+			continue
+		}
+		changes := []map[string]interface{}{}
+		if err := decodeJSONDocument(entry.Changes, &changes); err != nil {
+			return err
+		}
+		for _, c := range changes {
+			name, ok := fields[fmt.Sprint(c["field"])]
+			if !ok {
+				return fmt.Errorf("field %v is no longer in the history", c["field"])
+			}
+			f := v.FieldByName(name)
+			old, err := historyOldValue(c, f.Type())
+			if err != nil {
+				return fmt.Errorf("field %v can't be reverted : %s", c["field"], err.Error())
+			}
+			f.Set(old)
+		}
+	}
+	return nil
+}
+
+//historyOldValue return the old value of a change from its json, or parsed from its display for the entries without json
+func historyOldValue(change map[string]interface{}, t reflect.Type) (reflect.Value, error) {
+	raw, ok := change["oldJSON"]
+	if !ok {
+		return parseHistoryValue(fmt.Sprint(change["oldValue"]), t)
+	}
+	v := reflect.New(t)
+	b, err := json.Marshal(raw)
+	if err == nil {
+		err = json.Unmarshal(b, v.Interface())
+	}
+	return v.Elem(), err
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+//parseHistoryValue return the value of type t formatted by historyValue
+func parseHistoryValue(s string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	switch {
+	case t.Kind() == reflect.Ptr:
+		if s == "<nil>" {
+			return v, nil
+		}
+		elem, err := parseHistoryValue(s, t.Elem())
+		if err != nil {
+			return v, err
+		}
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(elem)
+		return v, nil
+	case t == reflect.TypeOf(time.Time{}):
+		d, err := time.Parse(time.RFC3339Nano, s)
+		v.Set(reflect.ValueOf(d))
+		return v, err
+	case reflect.PtrTo(t).Implements(textUnmarshalerType): //null types
+		switch s {
+		case "oui":
+			s = "true"
+		case "non":
+			s = "false"
+		}
+		return v, v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	var err error
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, t.Bits())
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var i uint64
+		i, err = strconv.ParseUint(s, 10, t.Bits())
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(s, t.Bits())
+		v.SetFloat(f)
+	default:
+		err = fmt.Errorf("unsupported type %s", t.String())
+	}
+	return v, err
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//MaxFilterDepth maximum nesting of and/or/not groups in a filter
+var MaxFilterDepth = 5
+
+//MaxFilterClauses maximum number of column conditions in a filter
+var MaxFilterClauses = 50
+
+//Filter a boolean filter expression, ex :
+//
+//	{"or": [{"status": {"eq": "open"}}, {"and": [{"assignee": 3}, {"not": {"price": {"gt": 10}}}]}]}
+//
+//a column given a plain value is filtered with its first declared mode (in, stringlike, year or an equality) like in the url,
+//several keys of the same object are ANDed
+type Filter map[string]json.RawMessage
+
+//ParseFilter decode a json filter
+func ParseFilter(data []byte) (Filter, error) {
+	f := Filter{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, &FilterError{Column: "filter", Operator: "json", Reason: err.Error()}
+	}
+	return f, nil
+}
+
+//Apply add the filter as a where group on req, checked against columns from FilterColumns
+//an invalid filter is added as a *FilterError to req.Error
+func (f Filter) Apply(req *gorm.DB, columns map[string]string) *gorm.DB {
+	if len(f) == 0 {
+		return req
+	}
+	clauses := 0
+	expr, err := f.compile(columns, 1, &clauses)
+	if err != nil {
+		req.AddError(err)
+		return req
+	}
+	return req.Where(expr)
+}
+
+func (f Filter) compile(columns map[string]string, depth int, clauses *int) (clause.Expression, error) {
+	if depth > MaxFilterDepth {
+		return nil, &FilterError{Column: "filter", Operator: "depth", Reason: fmt.Sprintf("more than %d nested groups", MaxFilterDepth)}
+	}
+	//sorted keys to always build the same request
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	exprs := []clause.Expression{}
+	for _, k := range keys {
+		switch k {
+		case "and", "or":
+			children := []Filter{}
+			if err := json.Unmarshal(f[k], &children); err != nil || len(children) == 0 {
+				return nil, &FilterError{Column: "filter", Operator: k, Reason: "expected a non empty array of filters"}
+			}
+			group := []clause.Expression{}
+			for _, child := range children {
+				expr, err := child.compile(columns, depth+1, clauses)
+				if err != nil {
+					return nil, err
+				}
+				group = append(group, expr)
+			}
+			if k == "or" && len(group) > 1 {
+				exprs = append(exprs, clause.Or(group...))
+			} else {
+				exprs = append(exprs, clause.And(group...))
+			}
+		case "not":
+			child := Filter{}
+			if err := json.Unmarshal(f[k], &child); err != nil || len(child) == 0 {
+				return nil, &FilterError{Column: "filter", Operator: k, Reason: "expected a filter"}
+			}
+			expr, err := child.compile(columns, depth+1, clauses)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, clause.Not(expr))
+		default:
+			declaration, ok := columns[k]
+			if !ok {
+				return nil, &FilterError{Column: k, Operator: "", Reason: "column not filterable"}
+			}
+			conditions, err := parseFilterConditions(f[k])
+			if err != nil {
+				return nil, &FilterError{Column: k, Operator: "", Reason: err.Error()}
+			}
+			ops := make([]string, 0, len(conditions))
+			for op := range conditions {
+				ops = append(ops, op)
+			}
+			sort.Strings(ops)
+			for _, op := range ops {
+				if *clauses++; *clauses > MaxFilterClauses {
+					return nil, &FilterError{Column: k, Operator: op, Reason: fmt.Sprintf("more than %d conditions", MaxFilterClauses)}
+				}
+				if op == plainOperator {
+					exprs = append(exprs, plainFilter(k, declaration, conditions[op]))
+					continue
+				}
+				expr, err := compileFilterOperator(k, op, declaration, conditions[op])
+				if err != nil {
+					return nil, err
+				}
+				exprs = append(exprs, expr)
+			}
+		}
+	}
+	if len(exprs) == 0 {
+		return nil, &FilterError{Column: "filter", Operator: "", Reason: "empty filter"}
+	}
+	return clause.And(exprs...), nil
+}
+
+//plainOperator key of the conditions of a plain value
+const plainOperator = ""
+
+//parseFilterConditions read {"op": value, ...} or a plain value
+func parseFilterConditions(raw json.RawMessage) (map[string][]string, error) {
+	ret := map[string][]string{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	ops, ok := v.(map[string]interface{})
+	if !ok {
+		ops = map[string]interface{}{plainOperator: v}
+	}
+	for op, val := range ops {
+		switch t := val.(type) {
+		case []interface{}:
+			for _, e := range t {
+				ret[op] = append(ret[op], fmt.Sprint(e))
+			}
+		case map[string]interface{}:
+			return nil, fmt.Errorf("unexpected object for operator %s", op)
+		case nil:
+			if op == plainOperator {
+				return nil, fmt.Errorf("null value, use the null operator")
+			}
+			return nil, fmt.Errorf("null value for operator %s, use the null operator", op)
+		default:
+			ret[op] = []string{fmt.Sprint(t)}
+		}
+	}
+	return ret, nil
+}
+
+//GenericSearch return all elements matching the json filter from the body
+func GenericSearch(w http.ResponseWriter, r *http.Request, data Validation, freq func(r *http.Request, req *gorm.DB) *gorm.DB) {
+	filter := Filter{}
+	if err := utils.ReadJSON(r, &filter); err != nil && err != io.EOF {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidFilter, "Invalid filter : "+err.Error()))
+		return
+	}
+	genericGetQueryAll(w, r, data, freq, filter)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+
+	"github.com/loupzeur/go-crud-api/utils"
+)
+
+//NDJSONFlushRows number of rows written between two flushes of a ndjson list
+var NDJSONFlushRows = 100
+
+//genericStreamList write each row of the list request as a json line while it is scanned (Accept: application/x-ndjson)
+//the whole list is sent, without pagination nor count (page and pagesize are ignored), and the scan stop when the request is canceled
+func genericStreamList(w http.ResponseWriter, r *http.Request, data Validation, q *listQuery) {
+	if r.FormValue("include") != "" { //preloads are not run on scanned rows
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "include not available on ndjson lists"))
+		return
+	}
+	if q.selection != nil && len(q.selection.preloads) > 0 {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "associations fields not available on ndjson lists"))
+		return
+	}
+	ctx := r.Context()
+	rows, err := applySort(q.req, q.sortKeys).WithContext(ctx).Rows()
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, "Error while retrieving data"))
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", utils.NDJSONMediaType)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for n := 1; ctx.Err() == nil && rows.Next(); n++ {
+		tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface()
+		if err = GetDB().ScanRows(rows, tmp); err != nil {
+			break
+		}
+		if err = afterRead(r, data, tmp); err != nil {
+			break
+		}
+		if err = enc.Encode(q.selection.Filter(tmp)); err != nil {
+			break
+		}
+		if flusher != nil && n%NDJSONFlushRows == 0 {
+			flusher.Flush()
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil && ctx.Err() == nil { //the status is already sent
+		log.Println("ndjson list of", data.TableName(), "interrupted :", err.Error())
+	}
+}
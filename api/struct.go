@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 
+	"github.com/loupzeur/go-crud-api/utils"
 	"gorm.io/gorm"
 )
 
@@ -16,6 +17,31 @@ type Validation interface {
 	QueryAllFromRequest(r *http.Request, q *gorm.DB) *gorm.DB
 }
 
+//Includable to allow ?include= association loading on read and list
+//return the association paths (ex: "owner", "items.product") with the rights required to load them
+type Includable interface {
+	Includes() map[string]utils.RightBits
+}
+
+//Keyed to declare the primary key of the routes when it's not the integer {id} : an uuid, a slug or a composite key /api/table/{org}/{code}
+type Keyed interface {
+	PrimaryKeys() []Key
+}
+
+//AssociationRights rights required to list (Read) and to add, replace or remove (Write) the objects of an association
+//Access is the rights function of each added, replaced or removed object (all by default)
+type AssociationRights struct {
+	Read   utils.RightBits
+	Write  utils.RightBits
+	Access func(r *http.Request, data interface{}) bool
+}
+
+//Associable to manage many to many associations with /api/table/{id}/association routes
+//return the associations (ex: "tags") with their rights
+type Associable interface {
+	Associations() map[string]AssociationRights
+}
+
 //Authed implement an element to set the id
 type Authed interface {
 	SetUserEmitter(userID uint)
@@ -26,3 +52,44 @@ type HistoryAble interface {
 	GetHistoryFields() map[string]string
 	SetHistory([]map[string]interface{})
 }
+
+//SortAble to declare how some OrderColumns are sorted : SortNumeric, SortText, SortNoCase or SortCollate+name
+type SortAble interface {
+	SortTypes() map[string]string
+}
+
+//Aggregatable to allow GET /api/table/aggregate : return the columns allowed in ?group= and in the ?metrics= functions
+type Aggregatable interface {
+	GroupColumns() []string
+	AggregateColumns() []string
+}
+
+//Exportable to choose the columns of GET /api/table/export : headers, struct fields (or db columns) and formatters
+//by default all the db columns are exported
+type Exportable interface {
+	ExportColumns() []ExportColumn
+}
+
+//FieldSelectable to allow ?fields= on read and list : return the selectable columns and associations (ex: "id", "name", "owner.name")
+//an association allow all its fields
+type FieldSelectable interface {
+	SelectableFields() []string
+}
+
+//Versioned to use an integer version column for the ETag, incremented on each update
+//update and delete fail if the version changed since the object was loaded
+type Versioned interface {
+	VersionColumn() string
+}
+
+//SoftDeleteRights rights required to list the trash, restore and hard delete (?hard=true) soft deleted objects
+type SoftDeleteRights struct {
+	Trash   utils.RightBits
+	Restore utils.RightBits
+	Hard    utils.RightBits
+}
+
+//SoftDeletable to manage soft deleted objects (with a gorm.DeletedAt field) : add trash, restore and hard delete
+type SoftDeletable interface {
+	SoftDeleteRights() SoftDeleteRights
+}
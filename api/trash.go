@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/loupzeur/go-crud-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//GenericTrash list the soft deleted objects with the same filters, sort and pagination as GenericGetQueryAll
+func GenericTrash(w http.ResponseWriter, r *http.Request, data Validation, freq func(r *http.Request, req *gorm.DB) *gorm.DB) {
+	sch, field := deletedAtField(data)
+	if field == nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Soft delete not available"))
+		return
+	}
+	GenericGetQueryAll(w, r, data, func(r *http.Request, req *gorm.DB) *gorm.DB {
+		return freq(r, req).Unscoped().Where(sch.Table + "." + field.DBName + " IS NOT NULL")
+	})
+}
+
+//GenericRestore restore a soft deleted object, f is the delete rights function
+func GenericRestore(w http.ResponseWriter, r *http.Request, data Validation, f func(r *http.Request, data interface{}) bool) {
+	_, field := deletedAtField(data)
+	if field == nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidRequest, "Soft delete not available"))
+		return
+	}
+	tmp := reflect.New(reflect.TypeOf(data).Elem()).Interface().(Validation)
+	err := tmp.FindFromRequest(utils.WithQueryScopes(r, unscoped))
+	setUserEmitter(r, tmp)
+	if !f(r, tmp) {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Forbidden"))
+		return
+	}
+	if err != nil {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusNotFound, utils.CodeNotFound, "Not Found"))
+		return
+	}
+	if _, isZero := field.ValueOf(reflect.ValueOf(tmp).Elem()); isZero {
+		utils.RespondError(w, r, utils.NewProblem(http.StatusConflict, utils.CodeConflict, "Not deleted"))
+		return
+	}
+	old := cloneObject(tmp)
+	field.Set(reflect.ValueOf(tmp).Elem(), gorm.DeletedAt{})
+	if err = writeObject(r, AuditRestore, old, tmp, nil, restoreObject); err != nil {
+		respondSaveError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(tmp))
+	resp := utils.Message(true, "Restore successful")
+	resp["data"] = tmp
+	utils.Respond(w, resp)
+}
+
+//restoreObject save the restored data, the soft deleted row is only found unscoped
+func restoreObject(tx *gorm.DB, data Validation) error {
+	return saveObject(tx.Unscoped(), data)
+}
+
+//hardDelete check if the request ask a hard delete (?hard=true) and if the user is allowed
+//the Hard rights must be set, without them hard deletes are denied
+func hardDelete(r *http.Request, data Validation) (bool, bool) {
+	if r.FormValue("hard") != "true" {
+		return false, true
+	}
+	v, ok := data.(SoftDeletable)
+	if !ok {
+		return false, true
+	}
+	rights := v.SoftDeleteRights().Hard
+	return true, rights != utils.NoRight && utils.HasRightsRequest(r, rights)
+}
+
+//deletedAtField return the schema and the gorm.DeletedAt field of data
+func deletedAtField(data interface{}) (*schema.Schema, *schema.Field) {
+	sch, err := parseSchema(data)
+	if err != nil {
+		return nil, nil
+	}
+	for _, f := range sch.Fields {
+		if f.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return sch, f
+		}
+	}
+	return sch, nil
+}
+
+func unscoped(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
@@ -1,6 +1,6 @@
 module github.com/loupzeur/go-crud-api
 
-go 1.17
+go 1.18
 
 require github.com/jinzhu/gorm v1.9.16
 
@@ -14,10 +14,10 @@ require (
 	github.com/gogo/protobuf v1.3.1 // indirect
 	github.com/mattn/go-sqlite3 v1.14.5 // indirect
 	github.com/opentracing/basictracer-go v1.1.0 // indirect
-	github.com/opentracing/opentracing-go v1.2.0 // indirect
+	github.com/opentracing/opentracing-go v1.2.0
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rs/cors v1.8.0
-	github.com/uber/jaeger-client-go v2.29.1+incompatible // indirect
+	github.com/uber/jaeger-client-go v2.29.1+incompatible
 	github.com/uber/jaeger-lib v2.4.1+incompatible // indirect
 	github.com/yurishkuro/opentracing-tutorial v0.0.0-20210818182759-66f6cf96eb47 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"runtime/debug"
 
+	"github.com/loupzeur/go-crud-api/utils"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/log"
 )
@@ -30,7 +31,7 @@ func RecoverWrap(h http.Handler) http.Handler {
 				crash.LogFields(log.String("crash", err.Error()), log.String("stack", string(debug.Stack())))
 				crash.SetTag("error", true)
 				crash.SetTag("crash", true)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				utils.RespondError(w, req, utils.NewProblem(http.StatusInternalServerError, utils.CodeInternal, err.Error()))
 			}
 		}()
 		h.ServeHTTP(w, req)
@@ -35,6 +35,10 @@ func JwtAuthentication(next http.Handler) http.Handler {
 			if value.Name == curRoute.GetName() {
 				curRouter = value
 				if (userRight & value.Authorization) == value.Authorization {
+					//public route, the user is still given when authenticated
+					if tk, _ := parseToken(r); tk != nil {
+						r = r.WithContext(context.WithValue(r.Context(), "user", *tk))
+					}
 					next.ServeHTTP(w, r)
 					return
 				}
@@ -43,54 +47,15 @@ func JwtAuthentication(next http.Handler) http.Handler {
 
 		}
 
-		var response map[string]interface{}
-		tokenHeader := r.Header.Get("Authorization") //Grab the token from the header
-
-		if tokenHeader == "" { //Token is missing, returns with error code 403 Unauthorized
-			response = utils.Message(false, "Missing auth token")
-			w.WriteHeader(http.StatusForbidden)
-			w.Header().Add("Content-Type", "application/json")
-			utils.Respond(w, response)
-			return
-		}
-
-		splitted := strings.Split(tokenHeader, " ") //The token normally comes in format `Bearer {token-body}`, we check if the retrieved token matched this requirement
-		if len(splitted) != 2 {
-			response = utils.Message(false, "Invalid/Malformed auth token")
-			w.WriteHeader(http.StatusForbidden)
-			w.Header().Add("Content-Type", "application/json")
-			utils.Respond(w, response)
-			return
-		}
-
-		tokenPart := splitted[1] //Grab the token part, what we are truly interested in
-		tk := &utils.Token{}
-
-		token, err := jwt.ParseWithClaims(tokenPart, tk, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("token_password")), nil
-		})
-
-		if err != nil { //Malformed token, returns with http code 403 as usual
-			response = utils.Message(false, "Malformed authentication token")
-			w.WriteHeader(http.StatusForbidden)
-			w.Header().Add("Content-Type", "application/json")
-			utils.Respond(w, response)
-			return
-		}
-
-		if !token.Valid { //Token is invalid, maybe not signed on this server
-			response = utils.Message(false, "Token is not valid.")
-			w.WriteHeader(http.StatusForbidden)
-			w.Header().Add("Content-Type", "application/json")
-			utils.Respond(w, response)
+		tk, message := parseToken(r)
+		if tk == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.RespondError(w, r, utils.NewProblem(http.StatusUnauthorized, utils.CodeUnauthorized, message))
 			return
 		}
 
-		if uint32(tk.UserRights)&curRouter.Authorization != curRouter.Authorization { //Token is invalid, maybe not signed on this server
-			response = utils.Message(false, "Authorization required")
-			w.WriteHeader(http.StatusForbidden)
-			w.Header().Add("Content-Type", "application/json")
-			utils.Respond(w, response)
+		if uint32(tk.UserRights)&curRouter.Authorization != curRouter.Authorization { //the user lack rights of the route
+			utils.RespondError(w, r, utils.NewProblem(http.StatusForbidden, utils.CodeForbidden, "Authorization required"))
 			return
 		}
 
@@ -101,6 +66,35 @@ func JwtAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+//parseToken return the token of the Authorization header or the reason why it is invalid
+func parseToken(r *http.Request) (*utils.Token, string) {
+	tokenHeader := r.Header.Get("Authorization") //Grab the token from the header
+	if tokenHeader == "" {                       //Token is missing, returns with error code 401 Unauthorized
+		return nil, "Missing auth token"
+	}
+
+	splitted := strings.Split(tokenHeader, " ") //The token normally comes in format `Bearer {token-body}`, we check if the retrieved token matched this requirement
+	if len(splitted) != 2 {
+		return nil, "Invalid/Malformed auth token"
+	}
+
+	tokenPart := splitted[1] //Grab the token part, what we are truly interested in
+	tk := &utils.Token{}
+
+	token, err := jwt.ParseWithClaims(tokenPart, tk, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("token_password")), nil
+	})
+
+	if err != nil { //Malformed token, returns with http code 401 as usual
+		return nil, "Malformed authentication token"
+	}
+
+	if !token.Valid { //Token is invalid, maybe not signed on this server
+		return nil, "Token is not valid."
+	}
+	return tk, ""
+}
+
 //GetAllRoutes return all routes in the app
 func GetAllRoutes(w http.ResponseWriter, r *http.Request) {
 	msg := utils.Message(true, "All Routes")
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -89,6 +90,59 @@ func TestAuthNOk(t *testing.T) {
 	}
 }
 
+func TestAuthMissing(t *testing.T) {
+	router := mux.NewRouter().StrictSlash(true)
+	router.Use(JwtAuthentication)
+	router.Methods("GET").Path("/auth").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}).Name("auth")
+
+	Routes = utils.Routes{{Name: "auth", Pattern: "/auth", Authorization: 1}} //require right 1
+
+	req, _ := http.NewRequest("GET", "/auth", nil) //no token
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	t.Logf("Return Code : %d", rr.Code)
+
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != "Bearer" || rr.Header().Get("Content-Type") != utils.ProblemMediaType {
+		t.Errorf("Http api return error : %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestAuthPublic(t *testing.T) {
+	router := mux.NewRouter().StrictSlash(true)
+	router.Use(JwtAuthentication)
+	router.Methods("GET").Path("/public").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tk, ok := utils.GetAuthenticatedToken(r); ok {
+			fmt.Fprint(w, tk.UserId)
+			return
+		}
+		fmt.Fprint(w, "anonymous")
+	}).Name("public")
+
+	Routes = utils.Routes{{Name: "public", Pattern: "/public", Authorization: uint32(utils.NoRight)}}
+
+	expired := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), &utils.Token{UserId: 7, StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Hour).Unix()}})
+	expiredToken, _ := expired.SignedString([]byte(os.Getenv("token_password")))
+	forged, _ := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), &utils.Token{UserId: 7}).SignedString([]byte("not the secret"))
+	for _, v := range []struct{ header, user string }{
+		{"", "anonymous"},
+		{"Bearer " + genToken(7, 1), "7"}, //a valid token give the user to the public route
+		{"Bearer " + expiredToken, "anonymous"},
+		{"Bearer " + forged, "anonymous"},
+		{"Bearer garbage", "anonymous"},
+		{"garbage", "anonymous"},
+	} {
+		req, _ := http.NewRequest("GET", "/public", nil)
+		if v.header != "" {
+			req.Header.Set("Authorization", v.header)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.String() != v.user {
+			t.Errorf("%q : expected %s got %d %s", v.header, v.user, rr.Code, rr.Body.String())
+		}
+	}
+}
+
 func setTracing() io.Closer {
 	zipkinPropagator := zipkin.NewZipkinB3HTTPHeaderPropagator()
 	cfg := config.Configuration{
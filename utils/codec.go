@@ -0,0 +1,449 @@
+package utils
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Codec encode the responses and decode the bodies of a media type
+type Codec interface {
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+//ErrUnsupportedMediaType returned when no codec can read a body
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+//DefaultMediaType media type used without Accept or Content-Type header
+var DefaultMediaType = "application/json"
+
+//NDJSONMediaType newline delimited json, the lists are streamed with a row by line
+const NDJSONMediaType = "application/x-ndjson"
+
+//registered codecs by media type
+var codecs = map[string]Codec{
+	"application/json": JSONCodec{},
+	"application/xml":  XMLCodec{},
+	"text/xml":         XMLCodec{},
+	NDJSONMediaType:    JSONCodec{},
+}
+
+//codec order of the registration, used by the wildcards of Accept
+var mediaTypes = []string{"application/json", "application/xml", "text/xml", NDJSONMediaType}
+
+//RegisterCodec add or replace the codec of a media type, msgpack or cbor codecs are registered by the application
+func RegisterCodec(mediaType string, c Codec) {
+	if _, ok := codecs[mediaType]; !ok {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	codecs[mediaType] = c
+}
+
+//GetCodec return the codec of a media type, the +json types (application/merge-patch+json, ...) use the json codec
+func GetCodec(mediaType string) (Codec, bool) {
+	if c, ok := codecs[mediaType]; ok {
+		return c, true
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return codecs["application/json"], true
+	}
+	return nil, false
+}
+
+//NegotiateCodec return the media type and the codec of the best type of an Accept header
+func NegotiateCodec(accept string) (string, Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return DefaultMediaType, codecs[DefaultMediaType], true
+	}
+	type accepted struct {
+		mediaType string
+		q         float64
+	}
+	list := []accepted{}
+	for _, v := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			list = append(list, accepted{mediaType, q})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+	for _, v := range list {
+		switch {
+		case v.mediaType == "*/*":
+			return DefaultMediaType, codecs[DefaultMediaType], true
+		case strings.HasSuffix(v.mediaType, "/*"):
+			prefix := strings.TrimSuffix(v.mediaType, "*")
+			if strings.HasPrefix(DefaultMediaType, prefix) {
+				return DefaultMediaType, codecs[DefaultMediaType], true
+			}
+			for _, t := range mediaTypes {
+				if strings.HasPrefix(t, prefix) {
+					return t, codecs[t], true
+				}
+			}
+		default:
+			if c, ok := GetCodec(v.mediaType); ok {
+				return v.mediaType, c, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+//codecWriter a response writer with the negotiated codec
+type codecWriter struct {
+	http.ResponseWriter
+	mediaType string
+	codec     Codec
+}
+
+//WithCodec return w encoding the responses of Respond and RespondCode with the codec of mediaType
+func WithCodec(w http.ResponseWriter, mediaType string, c Codec) http.ResponseWriter {
+	return &codecWriter{ResponseWriter: w, mediaType: mediaType, codec: c}
+}
+
+//Flush send the buffered data to the client
+func (w *codecWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//Unwrap return the original response writer, used by http.ResponseController
+func (w *codecWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+//responseCodec return the codec of w, json by default
+func responseCodec(w http.ResponseWriter) (string, Codec) {
+	if cw, ok := w.(*codecWriter); ok {
+		return cw.mediaType, cw.codec
+	}
+	return "application/json", JSONCodec{}
+}
+
+//ResponseMediaType return the negotiated media type of w, json by default
+func ResponseMediaType(w http.ResponseWriter) string {
+	mediaType, _ := responseCodec(w)
+	return mediaType
+}
+
+//requestCodec return the codec of the Content-Type of r, the default one without Content-Type
+func requestCodec(r *http.Request) (Codec, bool) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return codecs[DefaultMediaType], true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	return GetCodec(mediaType)
+}
+
+//Negotiate answer 406 if no codec match the Accept header and 415 if the body has no codec
+//else call h with the response writer of the negotiated codec
+func Negotiate(h http.HandlerFunc) http.HandlerFunc {
+	return NegotiateResponse(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength != 0 && r.Body != nil && r.Body != http.NoBody {
+			if _, ok := requestCodec(r); !ok {
+				RespondError(w, r, NewProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "No codec for the Content-Type "+r.Header.Get("Content-Type")))
+				return
+			}
+		}
+		h(w, r)
+	})
+}
+
+//NegotiateResponse answer 406 if no codec match the Accept header else call h with the response writer of the negotiated codec
+//for the handlers reading their own body formats (csv, ...)
+func NegotiateResponse(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, c, ok := NegotiateCodec(r.Header.Get("Accept"))
+		if !ok {
+			RespondError(w, r, NewProblem(http.StatusNotAcceptable, CodeNotAcceptable, "No codec for the Accept header "+r.Header.Get("Accept")))
+			return
+		}
+		h(WithCodec(w, mediaType, c), r)
+	}
+}
+
+//JSONCodec the application/json codec
+type JSONCodec struct{}
+
+//Encode write v as json
+func (JSONCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+//Decode read the json of r in v
+func (JSONCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+//XMLCodec the application/xml codec : responses are written from their json form in a <response> element
+//(arrays as <item> elements), bodies are read back in their json form with the types of the target
+type XMLCodec struct{}
+
+//Encode write v as xml
+func (XMLCodec) Encode(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	if err := encodeXML(e, "response", doc); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+//Decode read the xml of r in v, the inverse of Encode : the elements are the json keys of v (the root name is ignored)
+//and the <item> elements the values of its arrays
+func (XMLCodec) Decode(r io.Reader, v interface{}) error {
+	root, err := decodeXML(xml.NewDecoder(r))
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(xmlValue(root, reflect.TypeOf(v)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+//xmlNode an element of a decoded xml document
+type xmlNode struct {
+	name     string
+	text     string
+	children []*xmlNode
+}
+
+//decodeXML read the first element of d and its children, io.EOF on an empty document
+func decodeXML(d *xml.Decoder) (*xmlNode, error) {
+	stack := []*xmlNode{}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF && len(stack) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			if stack = stack[:len(stack)-1]; len(stack) == 0 {
+				return n, nil
+			}
+		}
+	}
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+//xmlValue return the json value of n for the type t, nil for an empty element of a pointer or a non string type
+func xmlValue(n *xmlNode, t reflect.Type) interface{} {
+	pointer := false
+	for t != nil && t.Kind() == reflect.Ptr {
+		t, pointer = t.Elem(), true
+	}
+	if t == nil || t.Kind() == reflect.Interface || reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return xmlAny(n)
+	}
+	text := strings.TrimSpace(n.text)
+	if len(n.children) == 0 && text == "" && (pointer || t.Kind() != reflect.String) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return n.text
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		if json.Valid([]byte(text)) {
+			return json.RawMessage(text)
+		}
+		return text
+	case reflect.Struct:
+		ret := map[string]interface{}{}
+		for _, c := range n.children {
+			ret[c.name] = xmlValue(c, jsonFieldType(t, c.name))
+		}
+		return ret
+	case reflect.Map:
+		ret := map[string]interface{}{}
+		for _, c := range n.children {
+			ret[c.name] = xmlValue(c, t.Elem())
+		}
+		return ret
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return text
+		}
+		ret := make([]interface{}, 0, len(n.children))
+		for _, c := range n.children {
+			ret = append(ret, xmlValue(c, t.Elem()))
+		}
+		return ret
+	}
+	return xmlAny(n)
+}
+
+//xmlAny return the json value of n without type : an array if all its children are <item>, an object with children,
+//else the json literal (number, boolean, null) or the string of its text
+func xmlAny(n *xmlNode) interface{} {
+	if len(n.children) > 0 {
+		items := true
+		for _, c := range n.children {
+			items = items && c.name == "item"
+		}
+		if items {
+			ret := make([]interface{}, 0, len(n.children))
+			for _, c := range n.children {
+				ret = append(ret, xmlAny(c))
+			}
+			return ret
+		}
+		ret := map[string]interface{}{}
+		for _, c := range n.children {
+			ret[c.name] = xmlAny(c)
+		}
+		return ret
+	}
+	text := strings.TrimSpace(n.text)
+	if text == "" {
+		return nil
+	}
+	if c := text[0]; c != '"' && c != '{' && c != '[' && json.Valid([]byte(text)) {
+		return json.RawMessage(text)
+	}
+	return n.text
+}
+
+//jsonFieldType return the type of the field of the struct t decoded from the json key, like encoding/json
+//an exact name first then a case insensitive one, nil if not found
+func jsonFieldType(t reflect.Type, key string) reflect.Type {
+	var fold reflect.Type
+	var walk func(t reflect.Type) reflect.Type
+	walk = func(t reflect.Type) reflect.Type {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				if found := walk(ft); found != nil {
+					return found
+				}
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if name == key {
+				return f.Type
+			}
+			if fold == nil && strings.EqualFold(name, key) {
+				fold = f.Type
+			}
+		}
+		return nil
+	}
+	if found := walk(t); found != nil {
+		return found
+	}
+	return fold
+}
+
+//encodeXML write the json document v in the element name
+func encodeXML(e *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := encodeXML(e, k, t[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range t {
+			if err := encodeXML(e, "item", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		b, _ := json.Marshal(t)
+		if s, ok := t.(string); ok {
+			b = []byte(s)
+		}
+		if err := e.EncodeToken(xml.CharData(b)); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+//xmlName replace the characters not allowed in an element name
+func xmlName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || !(c == '-' || c == '.' || (c >= '0' && c <= '9'))) {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
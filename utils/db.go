@@ -1,22 +1,59 @@
 package utils
 
 import (
+	"context"
 	"net/http"
 
 	"gorm.io/gorm"
 )
 
+type queryScopesKey struct{}
+type keyConditionsKey struct{}
+
+//WithQueryScopes return the request with scopes to apply on DefaultFindFromRequest (selected fields, included associations, ...)
+func WithQueryScopes(r *http.Request, scopes ...func(*gorm.DB) *gorm.DB) *http.Request {
+	scopes = append(append([]func(*gorm.DB) *gorm.DB{}, QueryScopes(r)...), scopes...)
+	return r.WithContext(context.WithValue(r.Context(), queryScopesKey{}, scopes))
+}
+
+//QueryScopes return the scopes set on the request
+func QueryScopes(r *http.Request) []func(*gorm.DB) *gorm.DB {
+	scopes, _ := r.Context().Value(queryScopesKey{}).([]func(*gorm.DB) *gorm.DB)
+	return scopes
+}
+
+//WithKeyConditions return the request with the primary key conditions (column: value) used by DefaultFindFromRequest instead of the {id} url var
+func WithKeyConditions(r *http.Request, conditions map[string]interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), keyConditionsKey{}, conditions))
+}
+
+//KeyConditions return the primary key conditions set on the request
+func KeyConditions(r *http.Request) map[string]interface{} {
+	conditions, _ := r.Context().Value(keyConditionsKey{}).(map[string]interface{})
+	return conditions
+}
+
 func DefaultFindFromRequest(r *http.Request, db *gorm.DB, data interface{}) error {
-	id, err := ReadIntURL(r, "id")
-	if err != nil {
-		return err
+	var key interface{}
+	if conditions := KeyConditions(r); conditions != nil {
+		key = conditions
+	} else {
+		id, err := ReadIntURL(r, "id")
+		if err != nil {
+			return err
+		}
+		key = id
+	}
+	db = db.WithContext(r.Context())
+	for _, scope := range QueryScopes(r) {
+		db = scope(db)
 	}
-	if err := db.Set("gorm:auto_preload", true).WithContext(r.Context()).First(data, id).Error; err != nil {
+	if err := db.First(data, key).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
 func DefaultQueryAll(r *http.Request, q *gorm.DB) *gorm.DB {
-	return q.Set("gorm:auto_preload", true).WithContext(r.Context())
+	return q.WithContext(r.Context())
 }
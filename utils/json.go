@@ -52,8 +52,13 @@ func (j *JSON) Scan(value interface{}) error {
 		*j = nil
 		return nil
 	}
-	s, ok := value.([]byte)
-	if !ok {
+	var s []byte
+	switch v := value.(type) {
+	case []byte:
+		s = v
+	case string: //text columns of some drivers
+		s = []byte(v)
+	default:
 		return errors.New("invalid scan source")
 	}
 	*j = append((*j)[0:0], s...)
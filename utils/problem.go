@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+//ProblemMediaType media type of the errors (RFC 7807), application/problem+xml with the xml codec
+const ProblemMediaType = "application/problem+json"
+
+//ProblemTypeBase prefix of the type of the problems, followed by their code (set it to the url of your error documentation)
+var ProblemTypeBase = "urn:problem-type:"
+
+//Codes of the problems, stable to be used by the clients
+const (
+	CodeInvalidRequest       = "invalid_request"        //400, invalid parameter or not available feature
+	CodeInvalidBody          = "invalid_body"           //400, body can't be decoded
+	CodeInvalidFilter        = "invalid_filter"         //400, filter, sort, fields or include not allowed
+	CodeUnauthorized         = "unauthorized"           //401, missing or invalid token
+	CodeForbidden            = "forbidden"              //403, rights or rights function refused
+	CodeNotFound             = "not_found"              //404
+	CodeNotAcceptable        = "not_acceptable"         //406, no codec for Accept
+	CodeConflict             = "conflict"               //409
+	CodePreconditionFailed   = "precondition_failed"    //412, If-Match or version conflict
+	CodeUnsupportedMediaType = "unsupported_media_type" //415, no codec for Content-Type
+	CodeValidationFailed     = "validation_failed"      //422, Validate refused the object
+	CodeInternal             = "internal_error"         //500, database or encoding error
+)
+
+//codes by status, used when a problem is built from a status only (hook errors, ...)
+var statusCodes = map[int]string{
+	http.StatusBadRequest:           CodeInvalidRequest,
+	http.StatusUnauthorized:         CodeUnauthorized,
+	http.StatusForbidden:            CodeForbidden,
+	http.StatusNotFound:             CodeNotFound,
+	http.StatusNotAcceptable:        CodeNotAcceptable,
+	http.StatusConflict:             CodeConflict,
+	http.StatusPreconditionFailed:   CodePreconditionFailed,
+	http.StatusUnsupportedMediaType: CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:  CodeValidationFailed,
+	http.StatusInternalServerError:  CodeInternal,
+}
+
+//Problem an error response of RFC 7807, with a machine readable code and the trace id
+type Problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Code     string                 `json:"code"`
+	TraceID  string                 `json:"trace_id,omitempty"`
+	Errors   map[string]interface{} `json:"errors,omitempty"`
+}
+
+//NewProblem return the problem of a status with its code and detail
+func NewProblem(status int, code string, detail string) *Problem {
+	return &Problem{Type: ProblemTypeBase + code, Title: http.StatusText(status), Status: status, Detail: detail, Code: code}
+}
+
+//StatusProblem return the problem of a status with the code of the status
+func StatusProblem(status int, detail string) *Problem {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	return NewProblem(status, code, detail)
+}
+
+//ValidationProblem return the 422 problem of the response of Validate, its message is the detail and its other keys the errors
+func ValidationProblem(resp map[string]interface{}) *Problem {
+	p := NewProblem(http.StatusUnprocessableEntity, CodeValidationFailed, "")
+	for k, v := range resp {
+		switch k {
+		case "status":
+		case "message":
+			p.Detail = fmt.Sprint(v)
+		default:
+			if p.Errors == nil {
+				p.Errors = map[string]interface{}{}
+			}
+			p.Errors[k] = v
+		}
+	}
+	return p
+}
+
+//Error return the detail of the problem
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+//TraceID return the trace id of the request : the one of its span, or the X-Request-Id header
+var TraceID = func(r *http.Request) string {
+	if span := opentracing.SpanFromContext(r.Context()); span != nil {
+		v := reflect.Indirect(reflect.ValueOf(span.Context()))
+		if m := v.MethodByName("TraceID"); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 { //jaeger
+			return fmt.Sprint(m.Call(nil)[0].Interface())
+		}
+		if v.Kind() == reflect.Struct { //mocktracer, zipkin
+			if f := v.FieldByName("TraceID"); f.IsValid() && f.CanInterface() {
+				return fmt.Sprint(f.Interface())
+			}
+		}
+	}
+	return r.Header.Get("X-Request-Id")
+}
+
+//RespondError write the problem with its status, as application/problem+json or with the codec negotiated by Negotiate
+func RespondError(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.TraceID == "" {
+		p.TraceID = TraceID(r)
+	}
+	mediaType, codec := responseCodec(w)
+	switch mediaType {
+	case "application/json", NDJSONMediaType:
+		mediaType = ProblemMediaType
+	case "application/xml", "text/xml":
+		mediaType = "application/problem+xml"
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(p.Status)
+	codec.Encode(w, p)
+}
@@ -9,6 +9,7 @@ type Route struct {
 	Pattern       string           `json:"pattern"`
 	HandlerFunc   http.HandlerFunc `json:"-"`
 	Authorization uint32           `json:"auth"`
+	CacheControl  string           `json:"cache_control,omitempty"` //Cache-Control header of the responses
 }
 
 //Routes an array of route
@@ -20,6 +21,40 @@ func (r Routes) Append(routes []Route) Routes {
 	return r
 }
 
+//WithCacheControl set the Cache-Control policy of the GET routes, or of the named routes
+//the header is set by the handler of the route, the last policy given win
+func (r Routes) WithCacheControl(policy string, names ...string) Routes {
+	for i, v := range r {
+		if (len(names) == 0 && v.Method == "GET") || contains(names, v.Name) {
+			r[i].CacheControl = policy
+			r[i].HandlerFunc = cacheControl(policy, v.HandlerFunc)
+		}
+	}
+	return r
+}
+
+//cacheControl set the Cache-Control header before h unless a later policy already did
+func cacheControl(policy string, h http.HandlerFunc) http.HandlerFunc {
+	if h == nil {
+		return nil
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", policy)
+		}
+		h(w, r)
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
 //Get Return route by name
 func (r Routes) Get(name string) Route {
 	ret := Route{}
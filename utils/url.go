@@ -3,7 +3,6 @@ package utils
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"net/http"
 	"strconv"
 
@@ -15,17 +14,19 @@ func Message(status bool, message string) map[string]interface{} {
 	return map[string]interface{}{"status": status, "message": message}
 }
 
-//Respond Create a JSON response with headers
+//Respond Create a response with headers, in JSON or with the codec negotiated by Negotiate
 func Respond(w http.ResponseWriter, data map[string]interface{}) {
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
+	mediaType, codec := responseCodec(w)
+	w.Header().Add("Content-Type", mediaType)
+	codec.Encode(w, data)
 }
 
-//RespondCode Create a JSON response with headers
+//RespondCode Create a response with headers and a status code, in JSON or with the codec negotiated by Negotiate
 func RespondCode(w http.ResponseWriter, data map[string]interface{}, statusCode int) {
+	mediaType, codec := responseCodec(w)
+	w.Header().Add("Content-Type", mediaType)
 	w.WriteHeader(statusCode)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
+	codec.Encode(w, data)
 }
 
 //ReadInt return an int or a default value
@@ -37,9 +38,13 @@ func ReadInt(r *http.Request, param string, v int64) (int64, error) {
 	return strconv.ParseInt(p, 10, 64)
 }
 
-//ReadJSON read a json or return a response
+//ReadJSON read the body with the codec of its Content-Type, JSON by default
 func ReadJSON(r *http.Request, v interface{}) error {
-	err := json.NewDecoder(r.Body).Decode(v)
+	codec, ok := requestCodec(r)
+	if !ok {
+		return ErrUnsupportedMediaType
+	}
+	err := codec.Decode(r.Body, v)
 	if err != nil {
 		return err
 	}